@@ -0,0 +1,23 @@
+//go:build !plan9
+// +build !plan9
+
+package prolink
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// isAddrInUse reports whether the error was caused by the address already
+// being bound by another socket.
+func isAddrInUse(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+
+	return ok && sysErr.Err == syscall.EADDRINUSE
+}
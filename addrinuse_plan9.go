@@ -0,0 +1,7 @@
+package prolink
+
+// isAddrInUse reports whether the error was caused by the address already
+// being bound by another socket. Plan 9 does not report this condition.
+func isAddrInUse(err error) bool {
+	return false
+}
@@ -0,0 +1,235 @@
+package prolink
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// Artwork formats
+const (
+	ArtworkFormatOriginal ArtworkFormat = ""
+	ArtworkFormatJPEG     ArtworkFormat = "jpeg"
+	ArtworkFormatPNG      ArtworkFormat = "png"
+)
+
+// ArtworkFormat specifies the image format artwork is encoded as.
+type ArtworkFormat string
+
+// ErrNoArtwork is returned when decoding the artwork of a track which does not
+// have any artwork.
+var ErrNoArtwork = fmt.Errorf("The track has no artwork")
+
+// ArtworkImage decodes the artwork of the track. The format name of the
+// artwork, either "jpeg" or "png", is returned along with the image.
+func (t *Track) ArtworkImage() (image.Image, string, error) {
+	if len(t.Artwork) == 0 {
+		return nil, "", ErrNoArtwork
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(t.Artwork))
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to decode artwork: %s", err)
+	}
+
+	return img, format, nil
+}
+
+// GetArtwork queries the remote db for the artwork of the given ID, as found
+// in the ArtworkID of a MenuItem. The artwork is transcoded
+// according to the ArtworkConfig and cached in the same way as the artwork of
+// tracks. An empty artwork is returned if the device does not have the
+// artwork.
+func (rd *RemoteDB) GetArtwork(deviceID DeviceID, slot TrackSlot, artworkID uint32) ([]byte, error) {
+	if !rd.IsLinked(deviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	q := &TrackQuery{
+		DeviceID:  deviceID,
+		Slot:      slot,
+		artworkID: artworkID,
+	}
+
+	var artwork []byte
+
+	err := rd.retryOnDisconnect(deviceID, func() (err error) {
+		devConn := rd.conn(deviceID)
+		if devConn == nil {
+			return ErrDeviceNotLinked
+		}
+
+		if err := devConn.acquire(); err != nil {
+			return err
+		}
+
+		defer devConn.release()
+
+		artwork, err = rd.getCachedArtwork(devConn, q)
+		return err
+	})
+
+	return artwork, err
+}
+
+// artworkJPEGQuality is the quality used when encoding JPEG artwork.
+const artworkJPEGQuality = 90
+
+// ArtworkConfig specifies how artwork retrieved from the remote database
+// should be transcoded before being returned.
+type ArtworkConfig struct {
+	// Format is the image format artwork will be encoded as. When left as
+	// ArtworkFormatOriginal the format of the artwork is not changed.
+	Format ArtworkFormat
+
+	// Size is the width and height in pixels that artwork will be scaled to
+	// fit within, preserving the aspect ratio. When zero the artwork is not
+	// scaled.
+	Size int
+
+	// HiRes requests the larger rendition of the artwork served by nexus 2
+	// and newer players, rather than the 80x80 thumbnail. Players which do
+	// not have the larger rendition will return the thumbnail.
+	HiRes bool
+}
+
+// needsTranscode reports whether the configuration changes the artwork.
+func (c ArtworkConfig) needsTranscode() bool {
+	return c.Format != ArtworkFormatOriginal || c.Size > 0
+}
+
+// transcodeArtwork decodes the artwork image data and re-encodes it using the
+// given artwork configuration.
+func transcodeArtwork(data []byte, config ArtworkConfig) ([]byte, error) {
+	if len(data) == 0 || !config.needsTranscode() {
+		return data, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode artwork: %s", err)
+	}
+
+	if config.Size > 0 {
+		img = scaleImage(img, config.Size)
+	}
+
+	if config.Format != ArtworkFormatOriginal {
+		format = string(config.Format)
+	}
+
+	buf := &bytes.Buffer{}
+
+	switch ArtworkFormat(format) {
+	case ArtworkFormatJPEG:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: artworkJPEGQuality})
+	case ArtworkFormatPNG:
+		err = png.Encode(buf, img)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode artwork: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleImage scales the image using bilinear interpolation to fit within a
+// square of the given size, preserving the aspect ratio.
+func scaleImage(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := size, size
+
+	if srcW > srcH {
+		dstH = size * srcH / srcW
+	}
+
+	if srcH > srcW {
+		dstW = size * srcW / srcH
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dstW, dstH))
+
+	scaleX := float64(srcW) / float64(dstW)
+	scaleY := float64(srcH) / float64(dstH)
+
+	for y := 0; y < dstH; y++ {
+		srcY := (float64(y)+0.5)*scaleY - 0.5
+
+		for x := 0; x < dstW; x++ {
+			srcX := (float64(x)+0.5)*scaleX - 0.5
+
+			dst.SetRGBA64(x, y, bilinearAt(src, srcX, srcY))
+		}
+	}
+
+	return dst
+}
+
+// bilinearAt samples the image at the fractional coordinates (relative to the
+// images bounds) by interpolating between the four nearest pixels.
+func bilinearAt(img image.Image, x, y float64) color.RGBA64 {
+	bounds := img.Bounds()
+
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > max {
+			return max
+		}
+		return v
+	}
+
+	x0, y0 := int(x), int(y)
+	if x < 0 {
+		x0 = -1
+	}
+	if y < 0 {
+		y0 = -1
+	}
+
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	maxX, maxY := bounds.Dx()-1, bounds.Dy()-1
+
+	px := [2]int{clamp(x0, maxX), clamp(x0+1, maxX)}
+	py := [2]int{clamp(y0, maxY), clamp(y0+1, maxY)}
+
+	var channels [4]float64
+
+	for j, sy := range py {
+		wy := fy
+		if j == 0 {
+			wy = 1 - fy
+		}
+
+		for i, sx := range px {
+			wx := fx
+			if i == 0 {
+				wx = 1 - fx
+			}
+
+			r, g, b, a := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+
+			channels[0] += float64(r) * wx * wy
+			channels[1] += float64(g) * wx * wy
+			channels[2] += float64(b) * wx * wy
+			channels[3] += float64(a) * wx * wy
+		}
+	}
+
+	return color.RGBA64{
+		R: uint16(channels[0] + 0.5),
+		G: uint16(channels[1] + 0.5),
+		B: uint16(channels[2] + 0.5),
+		A: uint16(channels[3] + 0.5),
+	}
+}
@@ -0,0 +1,108 @@
+package prolink
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultArtworkCacheSize is the number of bytes of artwork that will be
+// cached by default.
+const defaultArtworkCacheSize = 16 * 1024 * 1024
+
+// artworkKey identifies a piece of artwork. Artwork IDs are only unique to
+// the media slot the artwork was read from.
+type artworkKey struct {
+	deviceID  DeviceID
+	slot      TrackSlot
+	artworkID uint32
+}
+
+type artworkEntry struct {
+	key     artworkKey
+	artwork []byte
+}
+
+// artworkCache is a least recently used cache of artwork, bounded by the
+// total number of bytes of artwork held.
+type artworkCache struct {
+	lock    sync.Mutex
+	maxSize int
+	size    int
+	order   *list.List
+	entries map[artworkKey]*list.Element
+}
+
+// get returns the cached artwork for the key, marking it as recently used.
+func (c *artworkCache) get(key artworkKey) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return element.Value.(*artworkEntry).artwork, true
+}
+
+// add caches the artwork, evicting the least recently used artwork until the
+// cache is within its maximum size.
+func (c *artworkCache) add(key artworkKey, artwork []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(artwork) > c.maxSize {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&artworkEntry{key: key, artwork: artwork})
+	c.size += len(artwork)
+
+	for c.size > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove removes all cached artwork matching the given filter.
+func (c *artworkCache) remove(filter func(artworkKey) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, element := range c.entries {
+		if filter(key) {
+			c.removeElement(element)
+		}
+	}
+}
+
+// resize changes the maximum size of the cache, removing all cached artwork.
+func (c *artworkCache) resize(maxSize int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.maxSize = maxSize
+	c.size = 0
+	c.order.Init()
+	c.entries = map[artworkKey]*list.Element{}
+}
+
+func (c *artworkCache) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*artworkEntry)
+
+	delete(c.entries, entry.key)
+	c.size -= len(entry.artwork)
+}
+
+func newArtworkCache(maxSize int) *artworkCache {
+	return &artworkCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: map[artworkKey]*list.Element{},
+	}
+}
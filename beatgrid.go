@@ -0,0 +1,77 @@
+package prolink
+
+import (
+	"time"
+)
+
+// Beat is a single beat of a tracks beat grid.
+type Beat struct {
+	// BeatInMeasure is the position of the beat within its bar, from 1 to 4.
+	BeatInMeasure uint8
+
+	// BPM is the tempo of the track at this beat.
+	BPM float32
+
+	// Offset is the time of the beat from the start of the track.
+	Offset time.Duration
+}
+
+// The beat grid begins with a header, followed by fixed size beat entries.
+// Values within beat entries are little endian.
+const (
+	beatGridHeaderLen  = 0x14
+	beatGridEntryLen   = 0x10
+	beatEntryInMeasure = 0x00
+	beatEntryTempo     = 0x02
+	beatEntryOffset    = 0x04
+)
+
+// GetBeatGrid queries the remote db for the beat grid of a track, returning
+// every beat of the track.
+func (rd *RemoteDB) GetBeatGrid(q *TrackQuery) ([]*Beat, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	beatGridRequest := &beatGridRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+	}
+
+	resp, err := rd.executeRequest(q.DeviceID, beatGridRequest, msgTypeBeatGrid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.arguments) < 4 {
+		return []*Beat{}, nil
+	}
+
+	data, ok := resp.arguments[3].(fieldBinary)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+
+	return parseBeatGrid([]byte(data)), nil
+}
+
+// parseBeatGrid parses the beat entries of a beat grid.
+func parseBeatGrid(data []byte) []*Beat {
+	if len(data) < beatGridHeaderLen {
+		return []*Beat{}
+	}
+
+	data = data[beatGridHeaderLen:]
+	beats := make([]*Beat, 0, len(data)/beatGridEntryLen)
+
+	for ; len(data) >= beatGridEntryLen; data = data[beatGridEntryLen:] {
+		beats = append(beats, &Beat{
+			BeatInMeasure: data[beatEntryInMeasure],
+			BPM:           float32(le.Uint16(data[beatEntryTempo:])) / 100,
+			Offset:        time.Duration(le.Uint32(data[beatEntryOffset:])) * time.Millisecond,
+		})
+	}
+
+	return beats
+}
@@ -0,0 +1,464 @@
+package prolink
+
+import (
+	"fmt"
+	"io"
+)
+
+// BrowseAll may be used in place of a parent item ID when browsing nested
+// menus to include every item. This is equivalent to selecting the "ALL" item
+// in the players browse menus.
+const BrowseAll uint32 = 0xffffffff
+
+// Sort orders may be specified when browsing, they match the sort options
+// available in the players browse menus.
+const (
+	SortOrderDefault        SortOrder = 0x00
+	SortOrderTitle          SortOrder = 0x01
+	SortOrderArtist         SortOrder = 0x02
+	SortOrderAlbum          SortOrder = 0x03
+	SortOrderBPM            SortOrder = 0x04
+	SortOrderRating         SortOrder = 0x05
+	SortOrderGenre          SortOrder = 0x06
+	SortOrderComment        SortOrder = 0x07
+	SortOrderDuration       SortOrder = 0x08
+	SortOrderRemixer        SortOrder = 0x09
+	SortOrderLabel          SortOrder = 0x0a
+	SortOrderOriginalArtist SortOrder = 0x0b
+	SortOrderKey            SortOrder = 0x0c
+	SortOrderBitRate        SortOrder = 0x0d
+	SortOrderPlayCount      SortOrder = 0x10
+	SortOrderDateAdded      SortOrder = 0x11
+)
+
+// Labels associated to the sort orders
+var sortOrderLabels = map[SortOrder]string{
+	SortOrderDefault:        "default",
+	SortOrderTitle:          "title",
+	SortOrderArtist:         "artist",
+	SortOrderAlbum:          "album",
+	SortOrderBPM:            "bpm",
+	SortOrderRating:         "rating",
+	SortOrderGenre:          "genre",
+	SortOrderComment:        "comment",
+	SortOrderDuration:       "duration",
+	SortOrderRemixer:        "remixer",
+	SortOrderLabel:          "label",
+	SortOrderOriginalArtist: "original artist",
+	SortOrderKey:            "key",
+	SortOrderBitRate:        "bit rate",
+	SortOrderPlayCount:      "play count",
+	SortOrderDateAdded:      "date added",
+}
+
+// SortOrder specifies the order the items of a browse menu are sorted in by
+// the device.
+type SortOrder uint32
+
+// String returns the string representation of the sort order.
+func (o SortOrder) String() string {
+	return sortOrderLabels[o]
+}
+
+// BrowseQuery is used to make queries against the browse menus of a device.
+type BrowseQuery struct {
+	DeviceID DeviceID
+	Slot     TrackSlot
+
+	// Sort is the order the device will sort the menu items in. Not every
+	// menu supports every sort order, the device will use the default order
+	// of the menu for sort orders it does not support.
+	Sort SortOrder
+
+	// Offset and Limit select a window of the menu items to be returned. The
+	// offset is the index of the first item, starting from 0. A limit of 0
+	// returns every item following the offset. See Paginate to page through
+	// a menu.
+	Offset uint32
+	Limit  uint32
+}
+
+// BrowseItem is a single entry in a browse menu. For menus listing tracks the
+// ID is the track ID, and may be used to construct a TrackQuery.
+type BrowseItem struct {
+	ID   uint32
+	Name string
+}
+
+// GetGenres lists the genres available on the media in the queried slot.
+func (rd *RemoteDB) GetGenres(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuGenre)
+}
+
+// GetGenreArtists lists the artists who have tracks in the given genre.
+func (rd *RemoteDB) GetGenreArtists(q *BrowseQuery, genreID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuArtistsForGenre, genreID)
+}
+
+// GetGenreArtistAlbums lists the albums of an artist within the given genre.
+// BrowseAll may be passed as the artistID to list albums of every artist.
+func (rd *RemoteDB) GetGenreArtistAlbums(q *BrowseQuery, genreID, artistID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuAlbumsForGenreArtist, genreID, artistID)
+}
+
+// GetGenreTracks lists the tracks of the given genre, artist, and album.
+// BrowseAll may be passed as the artistID or albumID to list the tracks of
+// every artist or album within the genre.
+func (rd *RemoteDB) GetGenreTracks(q *BrowseQuery, genreID, artistID, albumID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuTracksForGenreArtistAlbum, genreID, artistID, albumID)
+}
+
+// GetBPMs lists the tempos of the tracks on the media in the queried slot. The
+// ID of each item is the tempo in BPM multiplied by 100.
+func (rd *RemoteDB) GetBPMs(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuBPM)
+}
+
+// GetBPMTracks lists the tracks with a tempo within a range of the given
+// tempo ID (as returned by GetBPMs). The distance is the range as a
+// percentage from 0 to 6, matching the players ± range sub-menu. For example
+// a tempoID of 12800 and a distance of 3 lists tracks from 124.16 to 131.84
+// BPM.
+func (rd *RemoteDB) GetBPMTracks(q *BrowseQuery, tempoID, distance uint32) ([]*BrowseItem, error) {
+	if distance > 6 {
+		return nil, fmt.Errorf("BPM distance must be at most 6%%, got %d%%", distance)
+	}
+
+	return rd.browse(q, msgTypeMenuTracksForBPMDistance, tempoID, distance)
+}
+
+// GetRatings lists the ratings of the tracks on the media in the queried slot.
+func (rd *RemoteDB) GetRatings(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuRating)
+}
+
+// GetRatingTracks lists the tracks with the given rating. The ratingID should
+// be the ID of one of the items returned by GetRatings, which corresponds to
+// the number of stars.
+func (rd *RemoteDB) GetRatingTracks(q *BrowseQuery, ratingID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuTracksForRating, ratingID)
+}
+
+// GetLabels lists the record labels of the tracks on the media in the queried
+// slot.
+func (rd *RemoteDB) GetLabels(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuLabel)
+}
+
+// GetLabelArtists lists the artists who have tracks released on the given
+// label.
+func (rd *RemoteDB) GetLabelArtists(q *BrowseQuery, labelID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuArtistsForLabel, labelID)
+}
+
+// GetLabelArtistAlbums lists the albums of an artist released on the given
+// label. BrowseAll may be passed as the artistID to list albums of every
+// artist.
+func (rd *RemoteDB) GetLabelArtistAlbums(q *BrowseQuery, labelID, artistID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuAlbumsForLabelArtist, labelID, artistID)
+}
+
+// GetLabelTracks lists the tracks of the given label, artist, and album.
+// BrowseAll may be passed as the artistID or albumID to list the tracks of
+// every artist or album on the label.
+func (rd *RemoteDB) GetLabelTracks(q *BrowseQuery, labelID, artistID, albumID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuTracksForLabelArtistAlbum, labelID, artistID, albumID)
+}
+
+// GetOriginalArtists lists the original artists of the tracks on the media in
+// the queried slot.
+func (rd *RemoteDB) GetOriginalArtists(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuOriginalArtist)
+}
+
+// GetOriginalArtistAlbums lists the albums containing tracks by the given
+// original artist.
+func (rd *RemoteDB) GetOriginalArtistAlbums(q *BrowseQuery, originalArtistID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuAlbumsForOriginalArtist, originalArtistID)
+}
+
+// GetOriginalArtistTracks lists the tracks of the given original artist and
+// album. BrowseAll may be passed as the albumID to list tracks from every
+// album.
+func (rd *RemoteDB) GetOriginalArtistTracks(q *BrowseQuery, originalArtistID, albumID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuTracksForOriginalAlbum, originalArtistID, albumID)
+}
+
+// GetHistories lists the history playlists stored on the media in the queried
+// slot. A history playlist is recorded by the players for each session the
+// media was used in.
+func (rd *RemoteDB) GetHistories(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuHistory)
+}
+
+// GetHistoryTracks lists the tracks of a history playlist, in the order they
+// were played.
+func (rd *RemoteDB) GetHistoryTracks(q *BrowseQuery, historyID uint32) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuTracksForHistory, historyID)
+}
+
+// GetTracksByDateAdded lists every track on the media in the queried slot,
+// sorted by the date the track was added to the rekordbox collection.
+func (rd *RemoteDB) GetTracksByDateAdded(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browseSorted(q, msgTypeMenuTrack, SortOrderDateAdded)
+}
+
+// GetKeys lists the musical keys of the tracks on the media in the queried
+// slot.
+func (rd *RemoteDB) GetKeys(q *BrowseQuery) ([]*BrowseItem, error) {
+	return rd.browse(q, msgTypeMenuKey)
+}
+
+// GetKeyTracks lists the tracks in the given musical key.
+func (rd *RemoteDB) GetKeyTracks(q *BrowseQuery, keyID uint32) ([]*BrowseItem, error) {
+	// The distance is the number of steps around the circle of fifths the
+	// player will include. We always request the exact key, compatible keys
+	// are handled by GetCompatibleKeyTracks.
+	return rd.browse(q, msgTypeMenuTracksForKeyDistance, keyID, 0)
+}
+
+// GetCompatibleKeyTracks lists the tracks which are in a key harmonically
+// compatible with the given key. Compatible keys are those adjacent on the
+// Camelot wheel: the same key, one step up or down, and the relative major or
+// minor. The key may be given in either standard (Am, F#) or Camelot (8A)
+// notation, matching how rekordbox may be configured to display keys.
+//
+// The Offset and Limit of the query select a window of the combined tracks of
+// every compatible key.
+func (rd *RemoteDB) GetCompatibleKeyTracks(q *BrowseQuery, key string) ([]*BrowseItem, error) {
+	compatible := compatibleKeys(key)
+	if compatible == nil {
+		return nil, fmt.Errorf("Unknown musical key: %q", key)
+	}
+
+	// Every key and track is listed, the window is applied to the result
+	all := *q
+	all.Offset = 0
+	all.Limit = 0
+
+	keys, err := rd.GetKeys(&all)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []*BrowseItem{}
+
+	for _, k := range keys {
+		if !compatible[camelotKey(k.Name)] {
+			continue
+		}
+
+		keyTracks, err := rd.GetKeyTracks(&all, k.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, keyTracks...)
+	}
+
+	if q.Offset >= uint32(len(tracks)) {
+		return []*BrowseItem{}, nil
+	}
+
+	tracks = tracks[q.Offset:]
+
+	if q.Limit > 0 && q.Limit < uint32(len(tracks)) {
+		tracks = tracks[:q.Limit]
+	}
+
+	return tracks, nil
+}
+
+// IterateTracks iterates every track on the media in the queried slot. Unlike
+// the other browse queries the tracks are not held in memory when the number
+// of tracks exceeds the threshold set by SetSpillThreshold, making this
+// suitable for very large collections.
+//
+// The iterator must be closed once iteration is complete.
+func (rd *RemoteDB) IterateTracks(q *BrowseQuery) (*BrowseIterator, error) {
+	request := &menuRequestPacket{
+		messageType: msgTypeMenuTrack,
+		sortOrder:   q.Sort,
+	}
+
+	return rd.iterateBrowse(q, request, rd.getSpillThreshold())
+}
+
+// GetAllTracks iterates every track on the media in the slot of the device,
+// allowing whatever media is mounted to be indexed offline. The tracks are
+// built from the rows of the track menu, which is paged through using
+// IterateTracks, so only the ID and Title of each track are filled in. Use
+// GetTrackMetadata to query the complete metadata of a track.
+//
+// The iterator must be closed once iteration is complete.
+func (rd *RemoteDB) GetAllTracks(deviceID DeviceID, slot TrackSlot) (*TrackIterator, error) {
+	q := &BrowseQuery{
+		DeviceID: deviceID,
+		Slot:     slot,
+	}
+
+	items, err := rd.IterateTracks(q)
+	if err != nil {
+		return nil, err
+	}
+
+	next := func() (*Track, error) {
+		if !items.Next() {
+			if err := items.Err(); err != nil {
+				return nil, err
+			}
+
+			return nil, io.EOF
+		}
+
+		item := items.Item()
+
+		return &Track{ID: item.ID, Title: item.Name}, nil
+	}
+
+	return &TrackIterator{next: next, close: items.Close}, nil
+}
+
+// Paginate returns an iterator which pages through the items of a browse
+// menu, requesting pageSize items at a time. The browse function is called to
+// request each page, with the Offset and Limit of the query set to the window
+// of the page. For example, to page through every track of a genre:
+//
+//	iter := rd.Paginate(q, 100, func(q *BrowseQuery) ([]*BrowseItem, error) {
+//		return rd.GetGenreTracks(q, genreID, BrowseAll, BrowseAll)
+//	})
+//
+// Paging begins at the Offset of the query, and stops after Limit items when
+// the Limit is not 0. A pageSize of 0 uses a default page size.
+func (rd *RemoteDB) Paginate(q *BrowseQuery, pageSize uint32, browse func(*BrowseQuery) ([]*BrowseItem, error)) *BrowseIterator {
+	if pageSize == 0 {
+		pageSize = menuBatchSize
+	}
+
+	page := *q
+	remaining := q.Limit
+	items := []*BrowseItem{}
+	done := false
+
+	next := func() (*BrowseItem, error) {
+		for len(items) == 0 {
+			if done {
+				return nil, io.EOF
+			}
+
+			page.Limit = pageSize
+			if q.Limit > 0 && remaining < pageSize {
+				page.Limit = remaining
+			}
+
+			pageItems, err := browse(&page)
+			if err != nil {
+				return nil, err
+			}
+
+			items = pageItems
+			page.Offset += page.Limit
+			remaining -= uint32(len(items))
+
+			done = uint32(len(items)) < page.Limit || (q.Limit > 0 && remaining == 0)
+		}
+
+		item := items[0]
+		items = items[1:]
+
+		return item, nil
+	}
+
+	return &BrowseIterator{next: next}
+}
+
+// browse requests a browse menu of the given message type from the remote
+// database, returning each item of the menu sorted in the sort order of the
+// query.
+func (rd *RemoteDB) browse(q *BrowseQuery, msgType uint16, parentIDs ...uint32) ([]*BrowseItem, error) {
+	return rd.browseSorted(q, msgType, q.Sort, parentIDs...)
+}
+
+// browseSorted requests a browse menu sorted in the given sort order.
+func (rd *RemoteDB) browseSorted(q *BrowseQuery, msgType uint16, sortOrder SortOrder, parentIDs ...uint32) ([]*BrowseItem, error) {
+	request := &menuRequestPacket{
+		messageType: msgType,
+		sortOrder:   sortOrder,
+		parentIDs:   parentIDs,
+	}
+
+	iter, err := rd.iterateBrowse(q, request, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer iter.Close()
+
+	items := []*BrowseItem{}
+
+	for iter.Next() {
+		items = append(items, iter.Item())
+	}
+
+	return items, iter.Err()
+}
+
+// iterateBrowse requests a browse menu, returning an iterator over the items
+// of the menu. The device ID and slot of the request are filled in from the
+// query. When the menu has more items than the spill threshold they will be
+// stored on disk.
+func (rd *RemoteDB) iterateBrowse(q *BrowseQuery, request *menuRequestPacket, spillThreshold int) (*BrowseIterator, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if q.Slot == TrackSlotCD {
+		return nil, ErrCDUnsupported
+	}
+
+	var iter *BrowseIterator
+
+	err := rd.retryOnDisconnect(q.DeviceID, func() (err error) {
+		iter, err = rd.executeBrowse(q, request, spillThreshold)
+		return err
+	})
+
+	return iter, err
+}
+
+func (rd *RemoteDB) executeBrowse(q *BrowseQuery, request *menuRequestPacket, spillThreshold int) (*BrowseIterator, error) {
+	devConn := rd.conn(q.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if err := devConn.acquire(); err != nil {
+		return nil, err
+	}
+
+	defer devConn.release()
+
+	request.deviceID = rd.requestingDeviceID()
+	request.slot = q.Slot
+
+	renderRequest := &renderRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+	}
+
+	collector := &itemCollector{spillThreshold: spillThreshold}
+
+	err := rd.eachMenuItemWindow(devConn, request, renderRequest, q.Offset, q.Limit, func(item *menuItem) error {
+		return collector.add(&BrowseItem{
+			ID:   item.num,
+			Name: item.text1,
+		})
+	})
+
+	if err != nil {
+		collector.discard()
+		return nil, err
+	}
+
+	return collector.iterator()
+}
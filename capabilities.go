@@ -0,0 +1,135 @@
+package prolink
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrRequestUnsupported is returned by RemoteDB when querying a device which
+// was found not to support the request when it was linked.
+var ErrRequestUnsupported = fmt.Errorf("The device does not support the request")
+
+// Capabilities reports which features of the library are currently available
+// for a device on the network. Applications may use this to adapt to the
+// devices present, rather than encountering errors at runtime.
+type Capabilities struct {
+	// RemoteDB reports that the device serves a remote database which is
+	// currently linked, and may be used to query track metadata.
+	RemoteDB bool
+
+	// Metadata reports that the remote database responds to track metadata
+	// queries.
+	Metadata bool
+
+	// Waveform reports that the remote database responds to waveform
+	// queries.
+	Waveform bool
+
+	// SongStructure reports that the remote database responds to queries for
+	// the tagged sections of the analysis files, such as the song structure
+	// and color waveforms.
+	SongStructure bool
+
+	// Status reports that the device has sent CDJ status packets.
+	Status bool
+}
+
+// Capabilities reports which features are available for the given device.
+func (n *Network) Capabilities(dev *Device) *Capabilities {
+	linked := n.remoteDB.IsLinked(dev.ID)
+
+	return &Capabilities{
+		RemoteDB:      linked,
+		Metadata:      linked && n.remoteDB.supports(dev.ID, featureMetadata),
+		Waveform:      linked && n.remoteDB.supports(dev.ID, featureWaveform),
+		SongStructure: linked && n.remoteDB.supports(dev.ID, featureAnlzTag),
+		Status:        n.cdjMonitor.LastStatus(dev.ID) != nil,
+	}
+}
+
+// remoteDBFeature identifies a group of remote database requests that are
+// probed for support when linking a device.
+type remoteDBFeature int
+
+// Remote database features
+const (
+	featureMetadata remoteDBFeature = iota
+	featureWaveform
+	featureAnlzTag
+)
+
+// featureProbes specifies the request sent to the device to probe for support
+// of each feature. The probes request a track that does not exist, any
+// response from the device indicates the request is supported.
+var featureProbes = map[remoteDBFeature]func(DeviceID) messagePacket{
+	featureMetadata: func(devID DeviceID) messagePacket {
+		return &metadataRequestPacket{deviceID: devID, slot: TrackSlotUSB}
+	},
+	featureWaveform: func(devID DeviceID) messagePacket {
+		return &waveformPreviewRequestPacket{deviceID: devID, slot: TrackSlotUSB}
+	},
+	featureAnlzTag: func(devID DeviceID) messagePacket {
+		return &anlzTagRequestPacket{
+			deviceID: devID,
+			slot:     TrackSlotUSB,
+			tag:      anlzTagSongStructure,
+			fileExt:  anlzFileExtended,
+		}
+	},
+}
+
+// probeFeatures sends each of the featureProbes to the device. A feature is
+// unsupported when the device does not respond to the probe within the dial
+// timeout. Since a late response would be read as the response to the next
+// request, the connection is replaced after an unanswered probe.
+func (dc *deviceConnection) probeFeatures(conn net.Conn) (net.Conn, map[remoteDBFeature]bool, error) {
+	features := map[remoteDBFeature]bool{}
+
+	for feature, probe := range featureProbes {
+		request := probe(dc.remoteDB.requestingDeviceID())
+		request.setTransactionID(dc.txCount)
+		dc.txCount++
+
+		conn.SetDeadline(time.Now().Add(dc.policy.DialTimeout))
+
+		_, err := conn.Write(request.bytes())
+		if err == nil {
+			_, err = readMessagePacket(conn)
+		}
+
+		conn.SetDeadline(time.Time{})
+
+		features[feature] = err == nil
+
+		if err == nil {
+			continue
+		}
+
+		conn.Close()
+
+		if conn, err = dc.dial(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return conn, features, nil
+}
+
+// supports reports whether the linked device supports the feature. Devices
+// that have not been probed are assumed to support every feature.
+func (rd *RemoteDB) supports(devID DeviceID, feature remoteDBFeature) bool {
+	devConn := rd.conn(devID)
+	if devConn == nil {
+		return true
+	}
+
+	devConn.connLock.Lock()
+	defer devConn.connLock.Unlock()
+
+	if devConn.features == nil {
+		return true
+	}
+
+	return devConn.features[feature]
+}
@@ -0,0 +1,166 @@
+package prolink
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// Players claim their device ID on startup by sending each stage of the
+// announce handshake three times, 300ms apart, before beginning to send
+// keepalives.
+const (
+	claimPacketCount    = 3
+	claimPacketInterval = 300 * time.Millisecond
+)
+
+// Announce packet types sent while claiming a device ID.
+const (
+	announceTypeClaim1 byte = 0x00
+	announceTypeClaim2 byte = 0x02
+	announceTypeClaim3 byte = 0x04
+	announceTypeHello  byte = 0x0a
+)
+
+// getClaimHeader constructs the header common to all announce packets,
+// including the total length of the packet.
+func getClaimHeader(packetType byte, dev *Device, length int) []byte {
+	// The name is a 20 byte string
+	name := make([]byte, 20)
+	copy(name[:], []byte(dev.Name))
+
+	packetLen := make([]byte, 2)
+	be.PutUint16(packetLen, uint16(length))
+
+	parts := [][]byte{
+		prolinkHeader,            // 0x00: 10 byte header
+		[]byte{packetType, 0x00}, // 0x0A: 02 byte announce packet type
+		name,                     // 0x0c: 20 byte device name
+		[]byte{0x01, 0x02},       // 0x20: 02 byte unknown
+		packetLen,                // 0x22: 02 byte packet length
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// getClaimPackets constructs each packet of the announce handshake, in the
+// order they are sent. The hello packets announce the device is joining the
+// network, followed by the three stages of claiming its device ID. Each
+// packet of a stage is numbered from 1 to 3.
+func getClaimPackets(dev *Device) [][]byte {
+	packets := [][]byte{}
+
+	for n := byte(1); n <= claimPacketCount; n++ {
+		packets = append(packets, bytes.Join([][]byte{
+			getClaimHeader(announceTypeHello, dev, 0x25),
+			[]byte{byte(dev.Type)}, // 0x24: 01 byte for the device type
+		}, nil))
+	}
+
+	for n := byte(1); n <= claimPacketCount; n++ {
+		packets = append(packets, bytes.Join([][]byte{
+			getClaimHeader(announceTypeClaim1, dev, 0x2c),
+			[]byte{n},              // 0x24: 01 byte packet number
+			[]byte{byte(dev.Type)}, // 0x25: 01 byte for the device type
+			dev.MacAddr[:6],        // 0x26: 06 byte mac address
+		}, nil))
+	}
+
+	for n := byte(1); n <= claimPacketCount; n++ {
+		packets = append(packets, bytes.Join([][]byte{
+			getClaimHeader(announceTypeClaim2, dev, 0x32),
+			dev.IP.To4(),           // 0x24: 04 byte IP address
+			dev.MacAddr[:6],        // 0x28: 06 byte mac address
+			[]byte{byte(dev.ID)},   // 0x2e: 01 byte for the player ID
+			[]byte{n},              // 0x2f: 01 byte packet number
+			[]byte{byte(dev.Type)}, // 0x30: 01 byte for the device type
+			[]byte{0x02},           // 0x31: 01 byte, the ID is manually assigned
+		}, nil))
+	}
+
+	for n := byte(1); n <= claimPacketCount; n++ {
+		packets = append(packets, bytes.Join([][]byte{
+			getClaimHeader(announceTypeClaim3, dev, 0x26),
+			[]byte{byte(dev.ID)}, // 0x24: 01 byte for the player ID
+			[]byte{n},            // 0x25: 01 byte packet number
+		}, nil))
+	}
+
+	return packets
+}
+
+// SetVirtualCDJIDRange configures the player IDs the virtual CDJ may assume
+// when AutoConfigure chooses an ID, or when the configured ID is found to be
+// in use by another device. Defaults to player IDs 1 through 4, or 1 through
+// 6 when every player on the network is a CDJ-3000.
+func (n *Network) SetVirtualCDJIDRange(ids ...DeviceID) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.idRange = ids
+}
+
+// freeVirtualCDJID returns the first ID of the virtual CDJ ID range which is
+// not in use by any device on the network. 0 is returned if every ID is in
+// use. The network lock must be held.
+func (n *Network) freeVirtualCDJID() DeviceID {
+	active := n.devManager.ActiveDeviceMap()
+
+	idRange := n.idRange
+	if idRange == nil {
+		idRange = defaultIDRange(active)
+	}
+
+	for _, id := range idRange {
+		if _, ok := active[id]; !ok {
+			return id
+		}
+	}
+
+	return 0x0
+}
+
+// defaultIDRange determines the player IDs available on the network. Six
+// players are only supported when every player is a CDJ-3000.
+func defaultIDRange(devices map[DeviceID]*Device) []DeviceID {
+	hasPlayers := false
+
+	for _, dev := range devices {
+		if dev.Type != DeviceTypeCDJ {
+			continue
+		}
+
+		if !strings.HasPrefix(dev.Name, "CDJ-3000") {
+			return prolinkIDRange
+		}
+
+		hasPlayers = true
+	}
+
+	if !hasPlayers {
+		return prolinkIDRange
+	}
+
+	return extendedIDRange
+}
+
+// resolveIDCollision is called as devices are added to the network. Should the
+// device be using the ID of the virtual CDJ the players will no longer respond
+// to the virtual CDJ, so a free ID is assumed in its place.
+func (n *Network) resolveIDCollision(dev *Device) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.VirtualCDJID == 0x0 || dev.ID != n.VirtualCDJID {
+		return
+	}
+
+	id := n.freeVirtualCDJID()
+	if id == 0x0 {
+		n.warner.warn(WarningIDCollision, nil, "%s is using the virtual CDJ ID %d, no free ID is available", dev, dev.ID)
+		return
+	}
+
+	n.warner.warn(WarningIDCollision, nil, "%s is using the virtual CDJ ID %d, switching to ID %d", dev, dev.ID, id)
+	n.setVirtualCDJID(id)
+}
@@ -0,0 +1,169 @@
+package prolink
+
+import (
+	"image/color"
+	"time"
+	"unicode/utf16"
+)
+
+// Cue point types
+const (
+	CuePointTypeCue  CuePointType = 0x01
+	CuePointTypeLoop CuePointType = 0x02
+)
+
+// Labels associated to the cue point types
+var cuePointTypeLabels = map[CuePointType]string{
+	CuePointTypeCue:  "cue",
+	CuePointTypeLoop: "loop",
+}
+
+// CuePointType represents the type of a cue point.
+type CuePointType byte
+
+// String returns the string representation of the cue point type.
+func (t CuePointType) String() string {
+	return cuePointTypeLabels[t]
+}
+
+// CuePoint is a memory cue, hot cue, or loop stored for a track.
+type CuePoint struct {
+	Type CuePointType
+
+	// HotCue is the number of the hot cue, starting at 1 for hot cue A. Memory
+	// cues and loops have a HotCue of 0.
+	HotCue int
+
+	// Position is the position of the cue within the track. For loops this is
+	// the start of the loop.
+	Position time.Duration
+
+	// LoopEnd is the end position of the loop. Cues have a LoopEnd of 0.
+	LoopEnd time.Duration
+
+	// Color is the color assigned to the cue. When no color is assigned the
+	// Color will be entirely zero.
+	Color   color.RGBA
+	Comment string
+}
+
+// IsHotCue reports whether the cue point is a hot cue.
+func (c *CuePoint) IsHotCue() bool {
+	return c.HotCue > 0
+}
+
+// HotCueLetter returns the letter of the hot cue as it is labeled on the
+// player. An empty string is returned if the cue point is not a hot cue.
+func (c *CuePoint) HotCueLetter() string {
+	if !c.IsHotCue() {
+		return ""
+	}
+
+	return string(rune('A' + c.HotCue - 1))
+}
+
+// Offsets of the fields within an extended cue list entry.
+const (
+	cueEntryLen         = 0x00
+	cueEntryHotCue      = 0x04
+	cueEntryType        = 0x06
+	cueEntryPosition    = 0x0c
+	cueEntryLoopEnd     = 0x10
+	cueEntryCommentLen  = 0x48
+	cueEntryComment     = 0x4a
+	cueEntryMinimumSize = 0x14
+)
+
+// GetCuePoints queries the remote db for the memory cues, hot cues, and loops
+// of a track.
+func (rd *RemoteDB) GetCuePoints(q *TrackQuery) ([]*CuePoint, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	cueListRequest := &cueListRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+	}
+
+	resp, err := rd.executeRequest(q.DeviceID, cueListRequest, msgTypeCueListExt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.arguments) < 5 {
+		return []*CuePoint{}, nil
+	}
+
+	data, dataOk := resp.arguments[3].(fieldBinary)
+	count, countOk := resp.arguments[4].(fieldNumber04)
+
+	if !dataOk || !countOk {
+		return nil, ErrInvalidRequest
+	}
+
+	return parseCuePoints([]byte(data), int(count)), nil
+}
+
+// parseCuePoints parses the entries of an extended cue list. Each entry is
+// variable length, starting with the length of the entry. All values are
+// little endian.
+func parseCuePoints(data []byte, count int) []*CuePoint {
+	cues := make([]*CuePoint, 0, count)
+
+	for i := 0; i < count && len(data) >= cueEntryMinimumSize; i++ {
+		entryLen := int(le.Uint32(data[cueEntryLen:]))
+		if entryLen < cueEntryMinimumSize || entryLen > len(data) {
+			break
+		}
+
+		entry := data[:entryLen]
+		data = data[entryLen:]
+
+		cue := &CuePoint{
+			Type:     CuePointTypeCue,
+			HotCue:   int(entry[cueEntryHotCue]),
+			Position: time.Duration(le.Uint32(entry[cueEntryPosition:])) * time.Millisecond,
+		}
+
+		if CuePointType(entry[cueEntryType]) == CuePointTypeLoop {
+			cue.Type = CuePointTypeLoop
+			cue.LoopEnd = time.Duration(le.Uint32(entry[cueEntryLoopEnd:])) * time.Millisecond
+		}
+
+		if len(entry) < cueEntryComment {
+			cues = append(cues, cue)
+			continue
+		}
+
+		// The comment is a null terminated UTF-16 little endian string
+		commentLen := int(le.Uint16(entry[cueEntryCommentLen:]))
+		colorOffset := cueEntryComment + commentLen
+
+		if commentLen >= 2 && colorOffset <= len(entry) {
+			comment := entry[cueEntryComment : colorOffset-2]
+			comment16 := make([]uint16, 0, len(comment)/2)
+
+			for ; len(comment) >= 2; comment = comment[2:] {
+				comment16 = append(comment16, le.Uint16(comment))
+			}
+
+			cue.Comment = string(utf16.Decode(comment16))
+		}
+
+		// Following the comment is a color code, then the RGB color
+		if colorOffset+4 <= len(entry) && entry[colorOffset] != 0 {
+			cue.Color = color.RGBA{
+				R: entry[colorOffset+1],
+				G: entry[colorOffset+2],
+				B: entry[colorOffset+3],
+				A: 0xff,
+			}
+		}
+
+		cues = append(cues, cue)
+	}
+
+	return cues
+}
@@ -1,8 +1,11 @@
 package prolink
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -26,7 +29,19 @@ type DeviceID byte
 
 // Device represents a device on the network.
 type Device struct {
-	Name       string
+	// Name is the name the device announces itself with. Hardware announces
+	// itself with its model name, rekordbox announces itself as "rekordbox".
+	Name string
+
+	// Model is the model of the device, such as "CDJ-3000" or
+	// "DJM-900nexus", as decoded from the announced name.
+	Model string
+
+	// Firmware is the firmware version of the device, such as "1.43". Only
+	// players report their firmware, in their status packets, so it is empty
+	// for other devices and until the first status of a player is received.
+	Firmware string
+
 	ID         DeviceID
 	Type       DeviceType
 	MacAddr    net.HardwareAddr
@@ -34,6 +49,16 @@ type Device struct {
 	LastActive time.Time
 }
 
+// sameAs reports whether the device has the same announced attributes as the
+// other device, ignoring when it was last active and its firmware.
+func (d *Device) sameAs(other *Device) bool {
+	return d.Name == other.Name &&
+		d.ID == other.ID &&
+		d.Type == other.Type &&
+		bytes.Equal(d.MacAddr, other.MacAddr) &&
+		d.IP.Equal(other.IP)
+}
+
 // String returns a string representation of a device.
 func (d *Device) String() string {
 	return fmt.Sprintf("%s %02d @ %s [%s]", d.Name, d.ID, d.IP, d.MacAddr)
@@ -52,135 +77,442 @@ type DeviceListenerFunc func(*Device)
 // OnChange implements the DeviceListener interface.
 func (f DeviceListenerFunc) OnChange(d *Device) { f(d) }
 
+// DeviceSubscription is returned when registering a DeviceListener. It may be
+// used to remove the listener once it is no longer needed.
+type DeviceSubscription struct {
+	manager  *DeviceManager
+	listener DeviceListener
+
+	// group is the subscription this subscription was registered as part of,
+	// such as the listeners backing a Devices channel.
+	group *DeviceSubscription
+
+	// inline listeners never block, and are called in the order devices
+	// change rather than each from their own goroutine.
+	inline bool
+}
+
+// Unsubscribe removes the listener from the DeviceManager. Unsubscribing more
+// than once has no effect.
+func (s *DeviceSubscription) Unsubscribe() {
+	s.manager.removeSubscriptions(func(sub *DeviceSubscription) bool {
+		return sub == s || sub.group == s
+	})
+}
+
+// notifyListeners calls each listener with a copy of the changed device, as
+// the LastActive time of the device is updated by each keepalive. The
+// DeviceManager lock must be held, so that inline listeners are called in
+// order.
+func notifyListeners(subs []*DeviceSubscription, dev *Device) {
+	for _, h := range subs {
+		devCopy := *dev
+
+		if h.inline {
+			h.listener.OnChange(&devCopy)
+		} else {
+			go h.listener.OnChange(&devCopy)
+		}
+	}
+}
+
 // DeviceManager provides functionality for watching the connection status of
 // PRO DJ LINK devices on the network.
 type DeviceManager struct {
-	delHandlers []DeviceListener
-	addHandlers []DeviceListener
+	delHandlers []*DeviceSubscription
+	addHandlers []*DeviceSubscription
+	updHandlers []*DeviceSubscription
 	devices     map[DeviceID]*Device
+	warner      *protocolWarner
+	activity    *listenerActivity
+	filter      *sourceFilter
+
+	// lock guards the devices as they are added by announce packets and
+	// removed once they miss their keepalives.
+	lock    *sync.Mutex
+	timeout time.Duration
+
+	// collisions records the device IDs a second device has been seen
+	// announcing itself with, so the collision is only reported once.
+	collisions map[DeviceID]bool
+
+	// runLock guards starting and stopping the DeviceManager. done is closed
+	// once the current announce listener stops.
+	runLock *sync.Mutex
+	conn    *net.UDPConn
+	done    chan bool
+	stopped bool
 }
 
 // OnDeviceAdded registers a listener that will be called when any PRO DJ LINK
 // devices are added to the network.
-func (m *DeviceManager) OnDeviceAdded(fn DeviceListener) {
-	m.addHandlers = append(m.addHandlers, fn)
+func (m *DeviceManager) OnDeviceAdded(fn DeviceListener) *DeviceSubscription {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sub := &DeviceSubscription{manager: m, listener: fn}
+	m.addHandlers = append(m.addHandlers, sub)
+
+	return sub
 }
 
 // OnDeviceRemoved registers a listener that will be called when any PRO DJ
-// LINK devices are removed from the network.
-func (m *DeviceManager) OnDeviceRemoved(fn DeviceListener) {
-	m.delHandlers = append(m.delHandlers, fn)
+// LINK devices are removed from the network. A device is removed once it has
+// not announced itself for the device timeout, such as when it is powered off
+// or unplugged. See SetDeviceTimeout.
+func (m *DeviceManager) OnDeviceRemoved(fn DeviceListener) *DeviceSubscription {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sub := &DeviceSubscription{manager: m, listener: fn}
+	m.delHandlers = append(m.delHandlers, sub)
+
+	return sub
+}
+
+// OnDeviceUpdated registers a listener that will be called when a device on
+// the network changes its attributes, such as its IP address being renewed or
+// a player being assigned a different player number. The listener is called
+// with the updated device.
+func (m *DeviceManager) OnDeviceUpdated(fn DeviceListener) *DeviceSubscription {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sub := &DeviceSubscription{manager: m, listener: fn}
+	m.updHandlers = append(m.updHandlers, sub)
+
+	return sub
+}
+
+// SetDeviceTimeout configures how long a device may go without announcing
+// itself before it is considered removed from the network. Devices announce
+// themselves every second and a half, networks which drop packets, such as
+// Wi-Fi, may need a longer timeout to avoid devices being removed and
+// immediately added again. Defaults to 10 seconds.
+func (m *DeviceManager) SetDeviceTimeout(timeout time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.timeout = timeout
 }
 
 // RemoveListener removes a DeviceListener that may have been added by
-// OnDeviceAdded or OnDeviceRemoved.
+// OnDeviceAdded, OnDeviceRemoved, or OnDeviceUpdated. Listeners which cannot
+// be compared, such as a DeviceListenerFunc, are not removed. Use the
+// DeviceSubscription returned when adding the listener instead.
 func (m *DeviceManager) RemoveListener(fn DeviceListener) {
-	k := 0
-	for _, handler := range m.addHandlers {
-		if handler != fn {
-			m.addHandlers[k] = handler
-			k++
-		}
+	if fn == nil || !reflect.TypeOf(fn).Comparable() {
+		return
 	}
-	m.addHandlers = m.addHandlers[:k]
 
-	k = 0
-	for _, handler := range m.delHandlers {
-		if handler != fn {
-			m.delHandlers[k] = handler
-			k++
+	m.removeSubscriptions(func(sub *DeviceSubscription) bool {
+		return sub.listener == fn
+	})
+}
+
+// removeSubscriptions removes the added, removed, and updated listeners
+// matching the filter.
+func (m *DeviceManager) removeSubscriptions(filter func(*DeviceSubscription) bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	remove := func(subs []*DeviceSubscription) []*DeviceSubscription {
+		kept := make([]*DeviceSubscription, 0, len(subs))
+
+		for _, sub := range subs {
+			if !filter(sub) {
+				kept = append(kept, sub)
+			}
 		}
+
+		return kept
 	}
-	m.delHandlers = m.delHandlers[:k]
+
+	m.addHandlers = remove(m.addHandlers)
+	m.delHandlers = remove(m.delHandlers)
+	m.updHandlers = remove(m.updHandlers)
 }
 
 // ActiveDeviceMap returns a mapping of device IDs to their associated devices.
+// The map is a snapshot of the devices at the time of the call.
 func (m *DeviceManager) ActiveDeviceMap() map[DeviceID]*Device {
-	return m.devices
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	devices := make(map[DeviceID]*Device, len(m.devices))
+
+	for id, dev := range m.devices {
+		devCopy := *dev
+		devices[id] = &devCopy
+	}
+
+	return devices
 }
 
-// ActiveDevices returns a list of active devices on the PRO DJ LINK network.
+// ActiveDevices returns a list of active devices on the PRO DJ LINK network,
+// ordered by device ID. The list is a snapshot of the devices at the time of
+// the call, including devices which announced themselves before any listeners
+// were registered.
 func (m *DeviceManager) ActiveDevices() []*Device {
-	devices := make([]*Device, 0, len(m.devices))
+	devMap := m.ActiveDeviceMap()
+	devices := make([]*Device, 0, len(devMap))
 
-	for _, dev := range m.devices {
+	for _, dev := range devMap {
 		devices = append(devices, dev)
 	}
 
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
 	return devices
 }
 
+// Stop stops the DeviceManager watching for devices. The active devices are
+// removed, notifying the OnDeviceRemoved listeners, but the registered
+// listeners are kept so that the DeviceManager may be started again using
+// Start, such as after switching interfaces. Stopping more than once has no
+// effect.
+func (m *DeviceManager) Stop() {
+	m.runLock.Lock()
+	defer m.runLock.Unlock()
+
+	if m.stopped {
+		return
+	}
+
+	m.stopped = true
+
+	// Interrupt the blocked read of the announce listener, without closing
+	// the announce connection shared with the virtual CDJ announcer.
+	if m.done != nil {
+		m.conn.SetReadDeadline(time.Now())
+		<-m.done
+		m.conn.SetReadDeadline(time.Time{})
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, dev := range m.devices {
+		delete(m.devices, id)
+		delete(m.collisions, id)
+
+		notifyListeners(m.delHandlers, dev)
+	}
+}
+
+// Start begins watching for devices again after the DeviceManager was
+// stopped. Devices are added as they next announce themselves. Starting a
+// running DeviceManager has no effect.
+func (m *DeviceManager) Start() {
+	m.runLock.Lock()
+	defer m.runLock.Unlock()
+
+	if !m.stopped {
+		return
+	}
+
+	m.stopped = false
+
+	if m.conn != nil {
+		m.listen()
+	}
+}
+
+// isStopped reports if the DeviceManager was stopped using Stop.
+func (m *DeviceManager) isStopped() bool {
+	m.runLock.Lock()
+	defer m.runLock.Unlock()
+
+	return m.stopped
+}
+
 // activate triggers the DeviceManager to begin watching for device changes on
-// the PRO DJ LINK network.
+// the PRO DJ LINK network. Should the DeviceManager have been stopped it will
+// not begin watching until started.
 func (m *DeviceManager) activate(announceConn *net.UDPConn) {
-	timeouts := map[DeviceID]*time.Timer{}
+	m.runLock.Lock()
+	defer m.runLock.Unlock()
+
+	m.conn = announceConn
+
+	if !m.stopped {
+		m.listen()
+	}
+}
 
-	timeoutTimer := func(dev *Device) {
-		timeouts[dev.ID] = time.NewTimer(deviceTimeout)
-		<-timeouts[dev.ID].C
+// listen begins listening for announce packets on the announce connection.
+// The runLock must be held.
+func (m *DeviceManager) listen() {
+	announceConn := m.conn
 
-		// Device timeout expired. No longer active
-		delete(timeouts, dev.ID)
-		delete(m.devices, dev.ID)
+	announceHandler := func() error {
+		packet := make([]byte, 512)
 
-		for _, h := range m.delHandlers {
-			go h.OnChange(dev)
+		n, addr, err := announceConn.ReadFromUDP(packet)
+		if err != nil {
+			return err
 		}
-	}
 
-	announceLock := sync.Mutex{}
+		if n == 0 || !m.filter.accepts(addr) {
+			return nil
+		}
 
-	announceHandler := func() {
-		packet := make([]byte, announcePacketLen)
+		m.activity.received()
 
-		announceConn.Read(packet)
-		dev, err := deviceFromAnnouncePacket(packet)
+		dev, err := deviceFromAnnouncePacket(packet[:n])
 		if err != nil {
-			return
+			m.warner.warnPacket(err, packet[:n])
+			return nil
+		}
+
+		if dev == nil {
+			return nil
 		}
 
 		if dev.Name == VirtualCDJName {
-			return
+			return nil
 		}
 
-		// Update device keepalive
-		if dev, ok := m.devices[dev.ID]; ok {
-			timeout, ok := timeouts[dev.ID]
-			if !ok {
-				return
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		existing, ok := m.devices[dev.ID]
+
+		// A different device announcing an ID already in use is not an
+		// update of the active device, neither device will work correctly
+		// until one is reconfigured.
+		if ok && !bytes.Equal(existing.MacAddr, dev.MacAddr) {
+			if !m.collisions[dev.ID] {
+				m.collisions[dev.ID] = true
+				m.warner.warn(WarningIDCollision, packet[:n], "%s is using the ID of %s", dev, existing)
 			}
 
-			timeout.Stop()
-			timeout.Reset(deviceTimeout)
-			dev.LastActive = time.Now()
-			return
+			return nil
 		}
 
-		announceLock.Lock()
-		defer announceLock.Unlock()
+		// A device which has changed its ID is recognized by its MAC address
+		if !ok {
+			existing, ok = m.deviceByMacAddr(dev.MacAddr)
+		}
 
 		// New device
-		m.devices[dev.ID] = dev
+		if !ok {
+			m.devices[dev.ID] = dev
 
-		for _, h := range m.addHandlers {
-			go h.OnChange(dev)
+			notifyListeners(m.addHandlers, dev)
+
+			return nil
+		}
+
+		// Update device keepalive
+		if existing.sameAs(dev) {
+			existing.LastActive = dev.LastActive
+			return nil
 		}
 
-		go timeoutTimer(dev)
+		// Device attributes changed
+		delete(m.devices, existing.ID)
+		delete(m.collisions, existing.ID)
+		dev.Firmware = existing.Firmware
+		m.devices[dev.ID] = dev
+
+		notifyListeners(m.updHandlers, dev)
+
+		return nil
 	}
 
-	// Begin listening for announce packets
+	generation := m.activity.start()
+	done := make(chan bool)
+
+	// Begin listening for announce packets until the connection is closed
 	go func() {
-		for {
-			announceHandler()
+		defer m.activity.stop(generation)
+		defer close(done)
+
+		for announceHandler() == nil {
 		}
 	}()
+
+	go m.sweepDevices(done)
+
+	m.done = done
+}
+
+// setFirmware records the firmware version reported in the status of a
+// player on the device of the player.
+func (m *DeviceManager) setFirmware(s *CDJStatus) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if dev, ok := m.devices[s.PlayerID]; ok {
+		dev.Firmware = s.Firmware
+	}
+}
+
+// deviceByMacAddr looks up an active device by its MAC address. The lock must
+// be held.
+func (m *DeviceManager) deviceByMacAddr(mac net.HardwareAddr) (*Device, bool) {
+	for _, dev := range m.devices {
+		if bytes.Equal(dev.MacAddr, mac) {
+			return dev, true
+		}
+	}
+
+	return nil, false
+}
+
+// deviceSweepInterval is how often devices are checked for having missed
+// their keepalives.
+const deviceSweepInterval = 1 * time.Second
+
+// sweepDevices removes devices which have not sent a keepalive within the
+// device timeout, until done is closed.
+func (m *DeviceManager) sweepDevices(done chan bool) {
+	ticker := time.NewTicker(deviceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			m.removeExpired(now)
+		}
+	}
+}
+
+// removeExpired removes the devices whose last keepalive was received over
+// the device timeout before now, notifying the OnDeviceRemoved listeners.
+func (m *DeviceManager) removeExpired(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, dev := range m.devices {
+		if now.Sub(dev.LastActive) < m.timeout {
+			continue
+		}
+
+		delete(m.devices, id)
+		delete(m.collisions, id)
+
+		notifyListeners(m.delHandlers, dev)
+	}
 }
 
-func newDeviceManager() *DeviceManager {
+func newDeviceManager(warner *protocolWarner, filter *sourceFilter) *DeviceManager {
 	return &DeviceManager{
-		addHandlers: []DeviceListener{},
-		delHandlers: []DeviceListener{},
+		addHandlers: []*DeviceSubscription{},
+		delHandlers: []*DeviceSubscription{},
+		updHandlers: []*DeviceSubscription{},
 		devices:     map[DeviceID]*Device{},
+		collisions:  map[DeviceID]bool{},
+		warner:      warner,
+		activity:    &listenerActivity{},
+		filter:      filter,
+		lock:        &sync.Mutex{},
+		timeout:     deviceTimeout,
+		runLock:     &sync.Mutex{},
 	}
 }
@@ -0,0 +1,143 @@
+package prolink
+
+// Device event types
+const (
+	DeviceEventAdded   DeviceEventType = "added"
+	DeviceEventRemoved DeviceEventType = "removed"
+	DeviceEventUpdated DeviceEventType = "updated"
+)
+
+// DeviceEventType is the kind of change a DeviceEvent reports.
+type DeviceEventType string
+
+// DeviceEvent is sent on the channel returned by DeviceManager.Devices when a
+// device is added, removed, or updated.
+type DeviceEvent struct {
+	Type   DeviceEventType
+	Device *Device
+}
+
+// Devices returns a channel receiving an event for each device added,
+// removed, or updated on the network. This is the channel equivalent of the
+// OnDeviceAdded, OnDeviceRemoved, and OnDeviceUpdated listeners.
+//
+// Events are sent in the order they occurred, buffered up to the given size.
+// Should the buffer be full when a device changes, the oldest event in the
+// buffer is dropped to make room, so a slow consumer always receives the most
+// recent changes. The returned subscription stops the events, the channel is
+// not closed.
+func (m *DeviceManager) Devices(buffer int) (<-chan *DeviceEvent, *DeviceSubscription) {
+	events := make(chan *DeviceEvent, buffer)
+	group := &DeviceSubscription{manager: m}
+
+	listener := func(t DeviceEventType) DeviceListener {
+		return DeviceListenerFunc(func(dev *Device) {
+			sendDeviceEvent(events, &DeviceEvent{Type: t, Device: dev})
+		})
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.addHandlers = append(m.addHandlers, &DeviceSubscription{
+		manager:  m,
+		listener: listener(DeviceEventAdded),
+		group:    group,
+		inline:   true,
+	})
+	m.delHandlers = append(m.delHandlers, &DeviceSubscription{
+		manager:  m,
+		listener: listener(DeviceEventRemoved),
+		group:    group,
+		inline:   true,
+	})
+	m.updHandlers = append(m.updHandlers, &DeviceSubscription{
+		manager:  m,
+		listener: listener(DeviceEventUpdated),
+		group:    group,
+		inline:   true,
+	})
+
+	return events, group
+}
+
+// sendDeviceEvent sends the event on the channel without blocking. Should the
+// channel buffer be full the oldest buffered event is dropped. Sends to the
+// same channel must not happen concurrently.
+func sendDeviceEvent(events chan *DeviceEvent, event *DeviceEvent) {
+	for {
+		select {
+		case events <- event:
+			return
+		default:
+		}
+
+		// An unbuffered channel without a waiting receiver drops the event
+		if cap(events) == 0 {
+			return
+		}
+
+		select {
+		case <-events:
+		default:
+		}
+	}
+}
+
+// statusChannel is the StatusHandler backing a Statuses channel. It is called
+// in order from the status listener and never blocks.
+type statusChannel struct {
+	statuses chan *CDJStatus
+}
+
+// OnStatusUpdate implements the StatusHandler interface. Should the channel
+// buffer be full the oldest buffered status is dropped.
+func (c *statusChannel) OnStatusUpdate(s *CDJStatus) {
+	for {
+		select {
+		case c.statuses <- s:
+			return
+		default:
+		}
+
+		if cap(c.statuses) == 0 {
+			return
+		}
+
+		select {
+		case <-c.statuses:
+		default:
+		}
+	}
+}
+
+// StatusSubscription is returned by CDJStatusMonitor.Statuses. It may be used
+// to stop receiving statuses once they are no longer needed.
+type StatusSubscription struct {
+	monitor *CDJStatusMonitor
+	channel *statusChannel
+}
+
+// Unsubscribe stops statuses being sent to the channel. Unsubscribing more
+// than once has no effect.
+func (s *StatusSubscription) Unsubscribe() {
+	s.monitor.removeChannel(s.channel)
+}
+
+// Statuses returns a channel receiving each status reported by the CDJs on
+// the network. This is the channel equivalent of OnStatusUpdate.
+//
+// Statuses are sent in the order they are received, buffered up to the given
+// size. CDJs report their status many times a second, should the buffer be
+// full when a status is received the oldest status in the buffer is dropped
+// to make room, so a slow consumer always receives the most recent statuses.
+// The returned subscription stops the statuses, the channel is not closed.
+func (sm *CDJStatusMonitor) Statuses(buffer int) (<-chan *CDJStatus, *StatusSubscription) {
+	channel := &statusChannel{
+		statuses: make(chan *CDJStatus, buffer),
+	}
+
+	sm.OnStatusUpdate(channel)
+
+	return channel.statuses, &StatusSubscription{monitor: sm, channel: channel}
+}
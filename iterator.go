@@ -0,0 +1,257 @@
+package prolink
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// BrowseIterator iterates over the items of a browse menu.
+//
+//	for iter.Next() {
+//		item := iter.Item()
+//	}
+//
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type BrowseIterator struct {
+	next  func() (*BrowseItem, error)
+	close func() error
+
+	item *BrowseItem
+	err  error
+}
+
+// Next advances the iterator to the next item. false is returned once there
+// are no more items or an error occurred.
+func (it *BrowseIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.item, it.err = it.next()
+
+	if it.err == io.EOF {
+		it.err = nil
+		it.item = nil
+		return false
+	}
+
+	return it.err == nil
+}
+
+// Item returns the current item of the iterator.
+func (it *BrowseIterator) Item() *BrowseItem {
+	return it.item
+}
+
+// Err returns the error that stopped iteration, if any.
+func (it *BrowseIterator) Err() error {
+	return it.err
+}
+
+// Close releases any resources held by the iterator.
+func (it *BrowseIterator) Close() error {
+	if it.close == nil {
+		return nil
+	}
+
+	return it.close()
+}
+
+// TrackIterator iterates over the metadata of tracks.
+//
+//	for iter.Next() {
+//		track := iter.Track()
+//	}
+//
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type TrackIterator struct {
+	next  func() (*Track, error)
+	close func() error
+
+	track *Track
+	err   error
+}
+
+// Next advances the iterator to the next track. false is returned once there
+// are no more tracks or an error occurred.
+func (it *TrackIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.track, it.err = it.next()
+
+	if it.err == io.EOF {
+		it.err = nil
+		it.track = nil
+		return false
+	}
+
+	return it.err == nil
+}
+
+// Track returns the current track of the iterator.
+func (it *TrackIterator) Track() *Track {
+	return it.track
+}
+
+// Err returns the error that stopped iteration, if any.
+func (it *TrackIterator) Err() error {
+	return it.err
+}
+
+// Close releases any resources held by the iterator.
+func (it *TrackIterator) Close() error {
+	if it.close == nil {
+		return nil
+	}
+
+	return it.close()
+}
+
+// sliceIterator constructs a BrowseIterator over items held in memory.
+func sliceIterator(items []*BrowseItem) *BrowseIterator {
+	next := func() (*BrowseItem, error) {
+		if len(items) == 0 {
+			return nil, io.EOF
+		}
+
+		item := items[0]
+		items = items[1:]
+
+		return item, nil
+	}
+
+	return &BrowseIterator{next: next}
+}
+
+// spillFile stores browse items in a temporary file so that very large menus
+// need not be held in memory.
+type spillFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *gob.Encoder
+}
+
+func newSpillFile() (*spillFile, error) {
+	file, err := ioutil.TempFile("", "prolink-browse-")
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+
+	spill := &spillFile{
+		file:    file,
+		writer:  writer,
+		encoder: gob.NewEncoder(writer),
+	}
+
+	return spill, nil
+}
+
+func (s *spillFile) add(item *BrowseItem) error {
+	return s.encoder.Encode(item)
+}
+
+// remove closes and deletes the temporary file.
+func (s *spillFile) remove() error {
+	s.file.Close()
+
+	return os.Remove(s.file.Name())
+}
+
+// iterator constructs a BrowseIterator reading back the items written to the
+// file. Closing the iterator removes the file.
+func (s *spillFile) iterator() (*BrowseIterator, error) {
+	if err := s.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	decoder := gob.NewDecoder(bufio.NewReader(s.file))
+
+	next := func() (*BrowseItem, error) {
+		item := &BrowseItem{}
+
+		if err := decoder.Decode(item); err != nil {
+			return nil, err
+		}
+
+		return item, nil
+	}
+
+	return &BrowseIterator{next: next, close: s.remove}, nil
+}
+
+// itemCollector collects browse items in memory until the spill threshold is
+// reached, at which point all items are moved into a spillFile.
+type itemCollector struct {
+	spillThreshold int
+
+	items []*BrowseItem
+	spill *spillFile
+}
+
+func (c *itemCollector) add(item *BrowseItem) error {
+	if c.spill != nil {
+		return c.spill.add(item)
+	}
+
+	c.items = append(c.items, item)
+
+	if c.spillThreshold == 0 || len(c.items) <= c.spillThreshold {
+		return nil
+	}
+
+	spill, err := newSpillFile()
+	if err != nil {
+		return err
+	}
+
+	c.spill = spill
+
+	for _, item := range c.items {
+		if err := spill.add(item); err != nil {
+			return err
+		}
+	}
+
+	c.items = nil
+
+	return nil
+}
+
+// discard releases any collected items.
+func (c *itemCollector) discard() {
+	if c.spill != nil {
+		c.spill.remove()
+	}
+
+	c.items = nil
+}
+
+// iterator constructs a BrowseIterator over the collected items.
+func (c *itemCollector) iterator() (*BrowseIterator, error) {
+	if c.spill == nil {
+		return sliceIterator(c.items), nil
+	}
+
+	iter, err := c.spill.iterator()
+	if err != nil {
+		c.discard()
+		return nil, err
+	}
+
+	return iter, nil
+}
@@ -0,0 +1,80 @@
+package prolink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// camelotWheel maps standard key notation to the Camelot wheel notation.
+// Enharmonic equivalents are both listed.
+var camelotWheel = map[string]string{
+	"Abm": "1A", "G#m": "1A", "B": "1B",
+	"Ebm": "2A", "D#m": "2A", "F#": "2B", "Gb": "2B",
+	"Bbm": "3A", "A#m": "3A", "Db": "3B", "C#": "3B",
+	"Fm": "4A", "Ab": "4B", "G#": "4B",
+	"Cm": "5A", "Eb": "5B", "D#": "5B",
+	"Gm": "6A", "Bb": "6B", "A#": "6B",
+	"Dm": "7A", "F": "7B",
+	"Am": "8A", "C": "8B",
+	"Em": "9A", "G": "9B",
+	"Bm": "10A", "D": "10B",
+	"F#m": "11A", "Gbm": "11A", "A": "11B",
+	"Dbm": "12A", "C#m": "12A", "E": "12B",
+}
+
+// camelotKey normalizes a key in either standard or Camelot notation into
+// Camelot notation. An empty string is returned for unknown keys.
+func camelotKey(key string) string {
+	key = strings.TrimSpace(key)
+
+	if camelot, ok := camelotWheel[key]; ok {
+		return camelot
+	}
+
+	key = strings.TrimLeft(strings.ToUpper(key), "0")
+	if len(key) < 2 {
+		return ""
+	}
+
+	num, err := strconv.Atoi(key[:len(key)-1])
+	if err != nil || num < 1 || num > 12 {
+		return ""
+	}
+
+	letter := key[len(key)-1]
+	if letter != 'A' && letter != 'B' {
+		return ""
+	}
+
+	return fmt.Sprintf("%d%c", num, letter)
+}
+
+// compatibleKeys returns the set of keys (in Camelot notation) that are
+// harmonically compatible with the given key. nil is returned if the key is
+// not known.
+func compatibleKeys(key string) map[string]bool {
+	camelot := camelotKey(key)
+	if camelot == "" {
+		return nil
+	}
+
+	num, _ := strconv.Atoi(camelot[:len(camelot)-1])
+	letter := camelot[len(camelot)-1]
+
+	relative := byte('A')
+	if letter == 'A' {
+		relative = 'B'
+	}
+
+	// Wrap around the 12 positions of the wheel
+	up := num%12 + 1
+	down := (num+10)%12 + 1
+
+	return map[string]bool{
+		camelot:                            true,
+		fmt.Sprintf("%d%c", up, letter):    true,
+		fmt.Sprintf("%d%c", down, letter):  true,
+		fmt.Sprintf("%d%c", num, relative): true,
+	}
+}
@@ -0,0 +1,197 @@
+package prolink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// mediaQueryTimeout is how long to wait for a player to respond to a media
+// query.
+const mediaQueryTimeout = 2 * time.Second
+
+// Length of media response packets
+const mediaResponsePacketLen = 0xc0
+
+// MediaSlotInfo describes the media mounted in a slot of a player.
+type MediaSlotInfo struct {
+	DeviceID DeviceID
+	Slot     TrackSlot
+
+	// Name is the name of the media, as configured in rekordbox.
+	Name string
+
+	// CreationDate is the date the media was first exported to by rekordbox,
+	// as formatted by rekordbox.
+	CreationDate string
+
+	TrackCount    int
+	PlaylistCount int
+
+	// TotalSize and FreeSpace are the capacity and remaining space of the
+	// media in bytes.
+	TotalSize uint64
+	FreeSpace uint64
+}
+
+// getMediaQueryPacket constructs the packet sent to a player to request the
+// details of the media in one of its slots.
+func getMediaQueryPacket(vCDJ *Device, devID DeviceID, slot TrackSlot) []byte {
+	// The name is a 20 byte string
+	name := make([]byte, 20)
+	copy(name[:], []byte(vCDJ.Name))
+
+	parts := [][]byte{
+		prolinkHeader,                           // 0x00: 10 byte header
+		[]byte{statusPacketMediaQuery, 0x00},    // 0x0A: 02 byte media query packet type
+		name,                                    // 0x0C: 20 byte device name
+		[]byte{0x01, 0x00, byte(vCDJ.ID), 0x00}, // 0x20: 04 byte requesting device ID
+		[]byte{0x0c},                            // 0x24: 01 byte unknown
+		vCDJ.IP.To4(),                           // 0x25: 04 byte IP address
+		[]byte{0x00, 0x00, 0x00, byte(devID)},   // 0x29: 04 byte queried device ID
+		[]byte{0x00, 0x00, 0x00, byte(slot)},    // 0x2D: 04 byte queried slot
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// mediaSlotInfoFromPacket constructs a MediaSlotInfo from a media response
+// packet.
+func mediaSlotInfoFromPacket(p []byte) (*MediaSlotInfo, error) {
+	if len(p) < mediaResponsePacketLen {
+		return nil, newPacketError(WarningUnexpectedLength, "Media response packet is %d bytes, expected at least %d", len(p), mediaResponsePacketLen)
+	}
+
+	info := &MediaSlotInfo{
+		DeviceID:      DeviceID(p[0x27]),
+		Slot:          TrackSlot(p[0x2B]),
+		Name:          decodeUTF16BE(p[0x2C : 0x2C+0x40]),
+		CreationDate:  decodeUTF16BE(p[0x6C : 0x6C+0x18]),
+		TrackCount:    int(be.Uint16(p[0xA6 : 0xA6+2])),
+		PlaylistCount: int(be.Uint16(p[0xAE : 0xAE+2])),
+		TotalSize:     be.Uint64(p[0xB0 : 0xB0+8]),
+		FreeSpace:     be.Uint64(p[0xB8 : 0xB8+8]),
+	}
+
+	return info, nil
+}
+
+// decodeUTF16BE decodes a null padded UTF-16 big endian string.
+func decodeUTF16BE(p []byte) string {
+	str16 := make([]uint16, 0, len(p)/2)
+
+	for ; len(p) >= 2; p = p[2:] {
+		c := be.Uint16(p)
+		if c == 0 {
+			break
+		}
+
+		str16 = append(str16, c)
+	}
+
+	return string(utf16.Decode(str16))
+}
+
+// mediaQueries tracks the media queries waiting for a response from a player.
+type mediaQueries struct {
+	lock    sync.Mutex
+	waiting map[mediaKey][]chan *MediaSlotInfo
+}
+
+// wait registers a channel that will receive the response for the slot.
+func (q *mediaQueries) wait(key mediaKey) chan *MediaSlotInfo {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	response := make(chan *MediaSlotInfo, 1)
+	q.waiting[key] = append(q.waiting[key], response)
+
+	return response
+}
+
+// cancel removes a channel registered with wait.
+func (q *mediaQueries) cancel(key mediaKey, response chan *MediaSlotInfo) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	waiting := q.waiting[key][:0]
+
+	for _, ch := range q.waiting[key] {
+		if ch != response {
+			waiting = append(waiting, ch)
+		}
+	}
+
+	q.waiting[key] = waiting
+}
+
+// respond delivers the media response to every query waiting for the slot.
+func (q *mediaQueries) respond(info *MediaSlotInfo) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := mediaKey{deviceID: info.DeviceID, slot: info.Slot}
+
+	for _, ch := range q.waiting[key] {
+		ch <- info
+	}
+
+	delete(q.waiting, key)
+}
+
+func newMediaQueries() *mediaQueries {
+	return &mediaQueries{
+		waiting: map[mediaKey][]chan *MediaSlotInfo{},
+	}
+}
+
+// GetMediaSlotInfo queries a player for the details of the media mounted in
+// one of its slots. The virtual CDJ must be configured for the player to
+// respond.
+func (n *Network) GetMediaSlotInfo(devID DeviceID, slot TrackSlot) (*MediaSlotInfo, error) {
+	n.lock.Lock()
+	passive := n.passive
+	iface := n.TargetInterface
+	vCDJID := n.VirtualCDJID
+	listenerConn := n.listenerConn
+	n.lock.Unlock()
+
+	if passive {
+		return nil, ErrPassive
+	}
+
+	if iface == nil || vCDJID == 0x0 {
+		return nil, fmt.Errorf("The virtual CDJ must be configured to query media")
+	}
+
+	dev, ok := n.devManager.ActiveDeviceMap()[devID]
+	if !ok {
+		return nil, fmt.Errorf("Device %d is not on the network", devID)
+	}
+
+	vCDJ, err := newVirtualCDJDevice(iface, vCDJID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to construct virtual CDJ: %s", err)
+	}
+
+	key := mediaKey{deviceID: devID, slot: slot}
+
+	response := n.cdjMonitor.mediaQueries.wait(key)
+	defer n.cdjMonitor.mediaQueries.cancel(key, response)
+
+	addr := &net.UDPAddr{IP: dev.IP, Port: listenerAddr.Port}
+
+	if _, err := listenerConn.WriteToUDP(getMediaQueryPacket(vCDJ, devID, slot), addr); err != nil {
+		return nil, fmt.Errorf("Failed to send media query: %s", err)
+	}
+
+	select {
+	case info := <-response:
+		return info, nil
+	case <-time.After(mediaQueryTimeout):
+		return nil, fmt.Errorf("Timed out waiting for media details of %s slot on device %d", slot, devID)
+	}
+}
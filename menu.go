@@ -0,0 +1,105 @@
+package prolink
+
+// MenuRequest is a low level request for any menu served by the remote
+// database. This may be used to issue menu requests which do not have a
+// dedicated RemoteDB method. The menu types and the arguments each menu
+// expects are documented in the dysentery protocol analysis.
+type MenuRequest struct {
+	DeviceID DeviceID
+	Slot     TrackSlot
+
+	// MenuType is the message type of the menu request, for example 0x1001
+	// requests the genre menu.
+	MenuType uint16
+
+	// Sort is the order the device will sort the menu items in.
+	Sort SortOrder
+
+	// Args are the arguments of the menu request following the sort order,
+	// typically the IDs of the parent items of nested menus.
+	Args []uint32
+
+	// Offset and Limit select a window of the menu items to be rendered. A
+	// limit of 0 renders every item following the offset.
+	Offset uint32
+	Limit  uint32
+}
+
+// MenuItem is a single rendered item of a menu.
+type MenuItem struct {
+	// ID is the primary numeric value of the item. For most menus this is the
+	// ID of the item, for others (such as the duration item of track
+	// metadata) it is the value of the item.
+	ID uint32
+
+	Text    string
+	SubText string
+
+	// Type is the menu item type, identifying what the item represents.
+	Type byte
+
+	// ArtworkID is the ID of the artwork associated to the item, or 0.
+	ArtworkID uint32
+}
+
+// RequestMenu requests a menu from the remote database and renders its items.
+func (rd *RemoteDB) RequestMenu(req *MenuRequest) ([]*MenuItem, error) {
+	if !rd.IsLinked(req.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	var items []*MenuItem
+
+	err := rd.retryOnDisconnect(req.DeviceID, func() (err error) {
+		items, err = rd.executeMenuRequest(req)
+		return err
+	})
+
+	return items, err
+}
+
+func (rd *RemoteDB) executeMenuRequest(req *MenuRequest) ([]*MenuItem, error) {
+	devConn := rd.conn(req.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if err := devConn.acquire(); err != nil {
+		return nil, err
+	}
+
+	defer devConn.release()
+
+	request := &menuRequestPacket{
+		messageType: req.MenuType,
+		deviceID:    rd.requestingDeviceID(),
+		slot:        req.Slot,
+		sortOrder:   req.Sort,
+		parentIDs:   req.Args,
+	}
+
+	renderRequest := &renderRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     req.Slot,
+	}
+
+	items := []*MenuItem{}
+
+	err := rd.eachMenuItemWindow(devConn, request, renderRequest, req.Offset, req.Limit, func(item *menuItem) error {
+		items = append(items, &MenuItem{
+			ID:        item.num,
+			Text:      item.text1,
+			SubText:   item.text2,
+			Type:      item.itemType,
+			ArtworkID: item.artworkID,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -2,19 +2,23 @@ package prolink
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
 var be = binary.BigEndian
+var le = binary.LittleEndian
 
 // We wait a second and a half to send keep alive packets for the virtual CDJ
 // we create on the PRO DJ LINK network.
 const keepAliveInterval = 1500 * time.Millisecond
 
-// How long to wait after before considering a device off the network.
+// How long to wait after before considering a device off the network, unless
+// configured using DeviceManager.SetDeviceTimeout.
 const deviceTimeout = 10 * time.Second
 
 // Length of device announce packets
@@ -32,6 +36,21 @@ var listenerAddr = &net.UDPAddr{
 	Port: 50002,
 }
 
+// announcePacketTypes are the packet types that are known to be sent to the
+// announce port. Only the keepalive (0x06) packets are used to track devices,
+// the others are sent while a device is claiming its device ID.
+var announcePacketTypes = map[byte]bool{
+	0x00: true,
+	0x01: true,
+	0x02: true,
+	0x03: true,
+	0x04: true,
+	0x05: true,
+	0x06: true,
+	0x08: true,
+	0x0a: true,
+}
+
 // All UDP packets on the PRO DJ LINK network start with this header.
 var prolinkHeader = []byte{
 	0x51, 0x73, 0x70, 0x74, 0x31,
@@ -42,6 +61,10 @@ var prolinkHeader = []byte{
 // network.
 var prolinkIDRange = []DeviceID{0x01, 0x02, 0x03, 0x04}
 
+// extendedIDRange is the set of player IDs that may exist on a prolink network
+// made up of CDJ-3000s, which support up to six players.
+var extendedIDRange = []DeviceID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+
 // getAnnouncePacket constructs the announce packet that is sent on the PRO DJ
 // LINK network to announce a devices existence.
 func getAnnouncePacket(dev *Device) []byte {
@@ -72,18 +95,30 @@ func getAnnouncePacket(dev *Device) []byte {
 }
 
 // deviceFromAnnouncePacket constructs a device object given a device
-// announcement packet.
+// announcement packet. If the packet is a known packet type that is not a
+// device keepalive nil will be returned.
 func deviceFromAnnouncePacket(packet []byte) (*Device, error) {
-	if !bytes.HasPrefix(packet, prolinkHeader) {
+	if !bytes.HasPrefix(packet, prolinkHeader) || len(packet) <= 0x0A {
 		return nil, fmt.Errorf("Announce packet does not start with expected header")
 	}
 
+	if !announcePacketTypes[packet[0x0A]] {
+		return nil, newPacketError(WarningUnknownPacketType, "Unknown announce packet type %#02x", packet[0x0A])
+	}
+
 	if packet[0x0A] != 0x06 {
-		return nil, fmt.Errorf("Packet is not an announce packet")
+		return nil, nil
 	}
 
+	if len(packet) < announcePacketLen {
+		return nil, newPacketError(WarningUnexpectedLength, "Announce packet is %d bytes, expected %d", len(packet), announcePacketLen)
+	}
+
+	name := string(bytes.TrimRight(packet[0x0C:0x0C+20], "\x00"))
+
 	dev := &Device{
-		Name:    string(bytes.TrimRight(packet[0x0C:0x0C+20], "\x00")),
+		Name:    name,
+		Model:   name,
 		ID:      DeviceID(packet[0x24]),
 		Type:    DeviceType(packet[0x34]),
 		MacAddr: net.HardwareAddr(packet[0x26 : 0x26+6]),
@@ -138,6 +173,57 @@ func getMatchingInterface(ip net.IP) (*net.Interface, error) {
 	return nil, fmt.Errorf("Failed to find matching interface for %s", ip)
 }
 
+// sourceFilter restricts the packets accepted from the network to those sent
+// from the subnets of the target interface. Hosts connected to multiple
+// networks may otherwise receive packets from devices on networks other than
+// the one the virtual CDJ is announced on.
+type sourceFilter struct {
+	lock    sync.Mutex
+	subnets []*net.IPNet
+}
+
+// setInterface restricts accepted packets to the subnets of the interface.
+func (f *sourceFilter) setInterface(iface *net.Interface) error {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	subnets := []*net.IPNet{}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			subnets = append(subnets, ipNet)
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.subnets = subnets
+
+	return nil
+}
+
+// accepts reports whether packets from the address should be handled. All
+// packets are accepted when no interface has been configured.
+func (f *sourceFilter) accepts(addr *net.UDPAddr) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.subnets) == 0 || addr == nil {
+		return true
+	}
+
+	for _, subnet := range f.subnets {
+		if subnet.Contains(addr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getBroadcastAddress determines the broadcast address to use for
 // communicating with the device.
 func getBroadcastAddress(dev *Device) *net.UDPAddr {
@@ -191,25 +277,45 @@ func newVirtualCDJDevice(iface *net.Interface, id DeviceID) (*Device, error) {
 // used to announce a "virtual CDJ" which allows the prolink library to recieve
 // more details from real CDJs on the network.
 type cdjAnnouncer struct {
-	cancel  chan bool
-	running bool
+	// cancel is closed to stop the running announcer, and is nil while the
+	// announcer is not running.
+	cancel chan struct{}
 }
 
 // start creates a goroutine that will continually announce a virtual CDJ
 // device on the host network.
 func (a *cdjAnnouncer) activate(vCDJ *Device, announceConn *net.UDPConn) {
-	if a.running == true {
+	if a.cancel != nil {
 		return
 	}
 
+	cancel := make(chan struct{})
+
 	broadcastAddrs := getBroadcastAddress(vCDJ)
 	announcePacket := getAnnouncePacket(vCDJ)
-	announceTicker := time.NewTicker(keepAliveInterval)
+	claimPackets := getClaimPackets(vCDJ)
 
 	go func() {
+		// Claim the device ID as a real player would on startup before
+		// beginning to send keepalives.
+		for _, packet := range claimPackets {
+			announceConn.WriteToUDP(packet, broadcastAddrs)
+
+			select {
+			case <-cancel:
+				return
+			case <-time.After(claimPacketInterval):
+			}
+		}
+
+		announceConn.WriteToUDP(announcePacket, broadcastAddrs)
+
+		announceTicker := time.NewTicker(keepAliveInterval)
+		defer announceTicker.Stop()
+
 		for {
 			select {
-			case <-a.cancel:
+			case <-cancel:
 				return
 			case <-announceTicker.C:
 				announceConn.WriteToUDP(announcePacket, broadcastAddrs)
@@ -217,24 +323,27 @@ func (a *cdjAnnouncer) activate(vCDJ *Device, announceConn *net.UDPConn) {
 		}
 	}()
 
-	a.running = true
+	a.cancel = cancel
 }
 
 // stop stops the running announcer
 func (a *cdjAnnouncer) deactivate() {
-	if a.running == true {
-		a.cancel <- true
+	if a.cancel != nil {
+		close(a.cancel)
+		a.cancel = nil
 	}
 }
 
 func newCDJAnnouncer() *cdjAnnouncer {
-	return &cdjAnnouncer{
-		cancel: make(chan bool),
-	}
+	return &cdjAnnouncer{}
 }
 
 // Network is the priamry API to the PRO DJ LINK network.
 type Network struct {
+	// lock guards the sockets and configuration of the network, which may be
+	// reconfigured by the supervisor while in use.
+	lock *sync.Mutex
+
 	announceConn *net.UDPConn
 	listenerConn *net.UDPConn
 
@@ -242,9 +351,15 @@ type Network struct {
 	cdjMonitor *CDJStatusMonitor
 	devManager *DeviceManager
 	remoteDB   *RemoteDB
+	warner     *protocolWarner
+	supervisor *supervisor
+	filter     *sourceFilter
+	idRange    []DeviceID
+	passive    bool
 
 	// TargetInterface specifies what network interface to broadcast announce
-	// packets for the virtual CDJ on.
+	// packets for the virtual CDJ on. Packets received from devices outside
+	// of the subnets of the interface are ignored.
 	//
 	// This field should not be reconfigured, use SetInterface instead to
 	// ensure the announce is correctly restarted on the new interface.
@@ -273,14 +388,32 @@ func (n *Network) RemoteDB() *RemoteDB {
 	return n.remoteDB
 }
 
+// Warnings returns a channel on which non-fatal ProtocolWarnings are reported
+// when unexpected data is received from devices on the network. Warnings are
+// dropped if the channel is not read from.
+func (n *Network) Warnings() <-chan *ProtocolWarning {
+	return n.warner.warnings
+}
+
 // SetVirtualCDJID configures the CDJ ID (Player ID) that the prolink library
 // should use to identify itself on the network. To correctly access metadata
-// on the network this *must* be in the range from 1-4, and should *not* be a
-// player ID that is already in use by a CDJ, otherwise the CDJ simply will not
-// respond. This is a known issue [1]
+// on the network this *must* be in the range from 1-4 (1-6 when every player
+// is a CDJ-3000), and should *not* be a player ID that is already in use by a
+// CDJ, otherwise the CDJ simply will not respond. This is a known issue [1]
+//
+// Should a device using the ID appear on the network, a free ID from the
+// range configured by SetVirtualCDJIDRange is assumed instead, and a
+// WarningIDCollision is reported.
 //
 // [1]: https://github.com/EvanPurkhiser/prolink-go/issues/6
 func (n *Network) SetVirtualCDJID(id DeviceID) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.setVirtualCDJID(id)
+}
+
+func (n *Network) setVirtualCDJID(id DeviceID) error {
 	n.VirtualCDJID = id
 	n.remoteDB.setRequestingDeviceID(id)
 
@@ -288,13 +421,34 @@ func (n *Network) SetVirtualCDJID(id DeviceID) error {
 }
 
 // SetInterface configures what network interface should be used when
-// announcing the Virtual CDJ.
+// announcing the Virtual CDJ. Once configured only packets received from
+// devices within the subnets of the interface are handled, so that hosts
+// connected to multiple networks, such as Wi-Fi, Ethernet, and VPNs, only
+// see the devices on the PRO DJ LINK network.
 func (n *Network) SetInterface(iface *net.Interface) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if err := n.filter.setInterface(iface); err != nil {
+		return fmt.Errorf("Failed to read interface addresses: %s", err)
+	}
+
 	n.TargetInterface = iface
 
 	return n.reloadAnnouncer()
 }
 
+// SetInterfaceAddr configures the network interface to use by the local IP
+// address assigned to the interface. See SetInterface.
+func (n *Network) SetInterfaceAddr(ip net.IP) error {
+	iface, err := getMatchingInterface(ip)
+	if err != nil {
+		return err
+	}
+
+	return n.SetInterface(iface)
+}
+
 // AutoConfigure attempts to configure the two confgiuration parameters of the
 // network.
 //
@@ -310,39 +464,22 @@ func (n *Network) SetInterface(iface *net.Interface) error {
 func (n *Network) AutoConfigure(wait time.Duration) error {
 	time.Sleep(wait)
 
-	playerIDs := []DeviceID{}
 	var CDJAddr net.IP
 
 	for _, device := range n.devManager.ActiveDevices() {
-		if device.Type != DeviceTypeCDJ {
-			continue
+		if device.Type == DeviceTypeCDJ {
+			CDJAddr = device.IP
 		}
-
-		playerIDs = append(playerIDs, device.ID)
-		CDJAddr = device.IP
 	}
 
-	if len(playerIDs) == 0 {
+	if CDJAddr == nil {
 		return fmt.Errorf("Could not autoconfigure network: no CDJs on network")
 	}
 
-	var unusedDeviceID DeviceID
-
-	// Choose an unused ID from the 4 available CDJ slots
-	for _, id := range prolinkIDRange {
-		isUnused := true
-
-		for _, usedID := range playerIDs {
-			if id == usedID {
-				isUnused = false
-			}
-		}
-
-		if isUnused {
-			unusedDeviceID = id
-			break
-		}
-	}
+	// Choose an unused ID from the available CDJ slots
+	n.lock.Lock()
+	unusedDeviceID := n.freeVirtualCDJID()
+	n.lock.Unlock()
 
 	if unusedDeviceID == 0x0 {
 		return fmt.Errorf("Could not autoconfigure network: No available Virtual CDJ slots")
@@ -362,7 +499,27 @@ func (n *Network) AutoConfigure(wait time.Duration) error {
 }
 
 func (n *Network) reloadAnnouncer() error {
-	if n.TargetInterface == nil || n.VirtualCDJID == 0x0 {
+	if n.TargetInterface == nil || n.VirtualCDJID == 0x0 || n.passive {
+		return nil
+	}
+
+	if err := n.restartAnnouncer(); err != nil {
+		return err
+	}
+
+	// Reload the remote remote DB service since we may now be announcing as a
+	// different device, we need to re-associate ourselves with the devices
+	// serving the remote database.
+	n.remoteDB.deactivate(n.devManager)
+	n.remoteDB.activate(n.devManager)
+
+	return nil
+}
+
+// restartAnnouncer restarts announcing the virtual CDJ using the current
+// configuration and announce connection.
+func (n *Network) restartAnnouncer() error {
+	if n.TargetInterface == nil || n.VirtualCDJID == 0x0 || n.passive {
 		return nil
 	}
 
@@ -374,26 +531,80 @@ func (n *Network) reloadAnnouncer() error {
 	n.announcer.deactivate()
 	n.announcer.activate(vCDJ, n.announceConn)
 
-	// Reload the remote remote DB service since we may now be announcing as a
-	// different device, we need to re-associate ourselves with the devices
-	// serving the remote database.
+	return nil
+}
+
+// restartListeners closes and reopens the UDP sockets, restarting the device
+// manager, CDJ monitor, and announcer on the new sockets.
+func (n *Network) restartListeners() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.announceConn != nil {
+		n.announceConn.Close()
+	}
+
+	if n.listenerConn != nil {
+		n.listenerConn.Close()
+	}
+
+	if err := n.openUDPConnections(); err != nil {
+		return err
+	}
+
+	n.devManager.activate(n.announceConn)
+	n.cdjMonitor.activate(n.listenerConn)
+
+	return n.restartAnnouncer()
+}
+
+// ErrPassive is returned when attempting to query devices while the network is
+// in passive mode.
+var ErrPassive = fmt.Errorf("The network is in passive mode")
+
+// SetPassive configures the network to never transmit anything. While passive
+// the virtual CDJ is not announced and the RemoteDB does not connect to
+// devices, only the packets broadcast by devices on the network are decoded.
+// This allows monitoring a network where active devices are not permitted.
+//
+// Note that without the virtual CDJ being announced, players may not send
+// their full status.
+func (n *Network) SetPassive(passive bool) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.passive = passive
+
+	if !passive {
+		return n.reloadAnnouncer()
+	}
+
+	n.announcer.deactivate()
 	n.remoteDB.deactivate(n.devManager)
-	n.remoteDB.activate(n.devManager)
 
 	return nil
 }
 
+// SetSilenceTimeout configures how long the network may go without receiving
+// any packets, after having previously received packets, before the network
+// sockets are assumed to have failed and are reopened. The sockets are only
+// reopened once until packets are received again, as the network is also
+// silent once every device has been switched off.
+func (n *Network) SetSilenceTimeout(timeout time.Duration) {
+	n.supervisor.setSilenceTimeout(timeout)
+}
+
 // openUDPConnection connects to the minimum required UDP sockets needed to
 // communicate with the Prolink network.
 func (n *Network) openUDPConnections() error {
-	listenerConn, err := net.ListenUDP("udp", listenerAddr)
+	listenerConn, err := listenReusableUDP(listenerAddr)
 	if err != nil {
 		return fmt.Errorf("Failed to open listener conection: %s", err)
 	}
 
 	n.listenerConn = listenerConn
 
-	announceConn, err := net.ListenUDP("udp", announceAddr)
+	announceConn, err := listenReusableUDP(announceAddr)
 	if err != nil {
 		return fmt.Errorf("Cannot open UDP announce connection: %s", err)
 	}
@@ -403,6 +614,25 @@ func (n *Network) openUDPConnections() error {
 	return nil
 }
 
+// listenReusableUDP opens a UDP socket on the address which may be shared with
+// other applications on the same host, such as rekordbox, provided they also
+// allow the address to be reused. An explanatory error is returned should the
+// address be held exclusively by another application.
+func listenReusableUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	config := net.ListenConfig{Control: reuseAddrControl}
+
+	conn, err := config.ListenPacket(context.Background(), "udp", addr.String())
+	if isAddrInUse(err) {
+		return nil, fmt.Errorf("Port %d is in use by another application, such as rekordbox: %s", addr.Port, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
+}
+
 // activeNetwork keeps a reference to the currently connected network.
 var activeNetwork *Network
 
@@ -425,16 +655,29 @@ func Connect() (*Network, error) {
 		return activeNetwork, nil
 	}
 
+	warner := newProtocolWarner()
+	filter := &sourceFilter{}
+
 	n := &Network{
+		lock:       &sync.Mutex{},
 		announcer:  newCDJAnnouncer(),
-		remoteDB:   newRemoteDB(),
-		devManager: newDeviceManager(),
-		cdjMonitor: newCDJStatusMonitor(),
+		remoteDB:   newRemoteDB(warner),
+		devManager: newDeviceManager(warner, filter),
+		cdjMonitor: newCDJStatusMonitor(warner, filter),
+		warner:     warner,
+		supervisor: newSupervisor(),
+		filter:     filter,
 	}
 
-	activeNetwork = n
+	if err := n.openUDPConnections(); err != nil {
+		if n.listenerConn != nil {
+			n.listenerConn.Close()
+		}
 
-	n.openUDPConnections()
+		return nil, err
+	}
+
+	activeNetwork = n
 
 	// We can start the device manager and CDJ monitor immediately as neither
 	// of these have any type of reconfiguration options other than then
@@ -442,8 +685,57 @@ func Connect() (*Network, error) {
 	n.devManager.activate(n.announceConn)
 	n.cdjMonitor.activate(n.listenerConn)
 
+	// Cached metadata is invalidated when players report their media has been
+	// unmounted.
+	n.cdjMonitor.OnStatusUpdate(StatusHandlerFunc(n.remoteDB.invalidateMedia))
+
+	// The firmware of players is only known from their status packets
+	n.cdjMonitor.OnStatusUpdate(StatusHandlerFunc(n.devManager.setFirmware))
+
+	// Players removed from the network are no longer part of the snapshot
+	n.devManager.OnDeviceRemoved(DeviceListenerFunc(n.cdjMonitor.removePlayer))
+
+	// Devices taking the ID of the virtual CDJ force it to assume another ID
+	n.devManager.OnDeviceAdded(DeviceListenerFunc(n.resolveIDCollision))
+	n.devManager.OnDeviceUpdated(DeviceListenerFunc(n.resolveIDCollision))
+
+	// The supervisor restarts the listeners should they fail
+	n.supervisor.activate(n)
+
 	// NOTE: We cannot start the remoteDB service until the Virtual CDJ has
 	// been announced on the network.
 
 	return n, nil
 }
+
+// Close disconnects from the PRO DJ LINK network. The virtual CDJ stops being
+// announced, causing players to remove it once it misses its keepalives, all
+// remote database connections are closed, and the UDP sockets are closed once
+// the network is no longer being supervised. Close waits for the listeners to
+// stop before returning, after which Connect may be called again.
+func (n *Network) Close() error {
+	n.supervisor.deactivate()
+
+	n.lock.Lock()
+	n.announcer.deactivate()
+	n.remoteDB.deactivate(n.devManager)
+
+	var err error
+
+	for _, conn := range []*net.UDPConn{n.announceConn, n.listenerConn} {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	n.lock.Unlock()
+
+	n.devManager.activity.wait()
+	n.cdjMonitor.activity.wait()
+
+	if activeNetwork == n {
+		activeNetwork = nil
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package prolink
+
+import (
+	"fmt"
+	"sync"
+)
+
+// pathIndex maps the file paths of the tracks on a media slot to their track
+// IDs. The remote database has no query to find a track by its path, so the
+// index is built by querying the path of every track on the media.
+type pathIndex struct {
+	lock  sync.Mutex
+	media map[mediaKey]map[string]uint32
+}
+
+func (i *pathIndex) get(key mediaKey) (map[string]uint32, bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	paths, ok := i.media[key]
+
+	return paths, ok
+}
+
+func (i *pathIndex) set(key mediaKey, paths map[string]uint32) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.media[key] = paths
+}
+
+// remove removes the indexes of the media slots matching the given filter.
+func (i *pathIndex) remove(filter func(mediaKey) bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for key := range i.media {
+		if filter(key) {
+			delete(i.media, key)
+		}
+	}
+}
+
+func newPathIndex() *pathIndex {
+	return &pathIndex{
+		media: map[mediaKey]map[string]uint32{},
+	}
+}
+
+// GetTrackIDByPath finds the ID of the track with the given file path on the
+// media in the slot of the device. The path must match the Path of the Track
+// exactly.
+//
+// The first lookup for a media slot queries the path of every track on the
+// media, which may take some time for large collections. The index is kept
+// until the media is unmounted.
+func (rd *RemoteDB) GetTrackIDByPath(deviceID DeviceID, slot TrackSlot, path string) (uint32, error) {
+	key := mediaKey{deviceID: deviceID, slot: slot}
+
+	paths, ok := rd.pathIndex.get(key)
+	if !ok {
+		var err error
+
+		if paths, err = rd.buildPathIndex(deviceID, slot); err != nil {
+			return 0, err
+		}
+
+		rd.pathIndex.set(key, paths)
+	}
+
+	trackID, ok := paths[path]
+	if !ok {
+		return 0, fmt.Errorf("No track with the path %q", path)
+	}
+
+	return trackID, nil
+}
+
+// buildPathIndex queries the path of every track on the media in the slot.
+func (rd *RemoteDB) buildPathIndex(deviceID DeviceID, slot TrackSlot) (map[string]uint32, error) {
+	paths := map[string]uint32{}
+
+	iter, err := rd.IterateTracks(&BrowseQuery{DeviceID: deviceID, Slot: slot})
+	if err != nil {
+		return nil, err
+	}
+
+	defer iter.Close()
+
+	for iter.Next() {
+		q := &TrackQuery{
+			DeviceID: deviceID,
+			Slot:     slot,
+			TrackID:  iter.Item().ID,
+		}
+
+		path, err := rd.getTrackPath(q)
+		if err != nil {
+			return nil, err
+		}
+
+		paths[path] = q.TrackID
+	}
+
+	return paths, iter.Err()
+}
+
+// getTrackPath queries the file path of a single track.
+func (rd *RemoteDB) getTrackPath(q *TrackQuery) (string, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return "", ErrDeviceNotLinked
+	}
+
+	var path string
+
+	err := rd.retryOnDisconnect(q.DeviceID, func() (err error) {
+		devConn := rd.conn(q.DeviceID)
+		if devConn == nil {
+			return ErrDeviceNotLinked
+		}
+
+		if err := devConn.acquire(); err != nil {
+			return err
+		}
+
+		defer devConn.release()
+
+		path, err = rd.queryTrackPath(devConn, q)
+		return err
+	})
+
+	return path, err
+}
@@ -0,0 +1,207 @@
+package prolink
+
+// Phrase moods
+const (
+	PhraseMoodHigh PhraseMood = 0x01
+	PhraseMoodMid  PhraseMood = 0x02
+	PhraseMoodLow  PhraseMood = 0x03
+)
+
+// Labels associated to the phrase moods
+var phraseMoodLabels = map[PhraseMood]string{
+	PhraseMoodHigh: "high",
+	PhraseMoodMid:  "mid",
+	PhraseMoodLow:  "low",
+}
+
+// PhraseMood is the overall mood of a track as determined by the phrase
+// analysis. The mood determines which kinds of phrases the track is made of.
+type PhraseMood int
+
+// String returns the string representation of the phrase mood.
+func (m PhraseMood) String() string {
+	return phraseMoodLabels[m]
+}
+
+// Phrase kinds
+const (
+	PhraseKindUnknown PhraseKind = ""
+	PhraseKindIntro   PhraseKind = "intro"
+	PhraseKindUp      PhraseKind = "up"
+	PhraseKindDown    PhraseKind = "down"
+	PhraseKindVerse1  PhraseKind = "verse1"
+	PhraseKindVerse2  PhraseKind = "verse2"
+	PhraseKindVerse3  PhraseKind = "verse3"
+	PhraseKindVerse4  PhraseKind = "verse4"
+	PhraseKindVerse5  PhraseKind = "verse5"
+	PhraseKindVerse6  PhraseKind = "verse6"
+	PhraseKindBridge  PhraseKind = "bridge"
+	PhraseKindChorus  PhraseKind = "chorus"
+	PhraseKindOutro   PhraseKind = "outro"
+)
+
+// PhraseKind identifies the kind of section of the song a phrase is.
+type PhraseKind string
+
+// phraseKinds maps the phrase kind IDs of each mood to the kind of phrase.
+var phraseKinds = map[PhraseMood]map[uint16]PhraseKind{
+	PhraseMoodHigh: {
+		0x01: PhraseKindIntro,
+		0x02: PhraseKindUp,
+		0x03: PhraseKindDown,
+		0x05: PhraseKindChorus,
+		0x06: PhraseKindOutro,
+	},
+	PhraseMoodMid: {
+		0x01: PhraseKindIntro,
+		0x02: PhraseKindVerse1,
+		0x03: PhraseKindVerse2,
+		0x04: PhraseKindVerse3,
+		0x05: PhraseKindVerse4,
+		0x06: PhraseKindVerse5,
+		0x07: PhraseKindVerse6,
+		0x08: PhraseKindBridge,
+		0x09: PhraseKindChorus,
+		0x0a: PhraseKindOutro,
+	},
+	PhraseMoodLow: {
+		0x01: PhraseKindIntro,
+		0x02: PhraseKindVerse1,
+		0x03: PhraseKindVerse1,
+		0x04: PhraseKindVerse1,
+		0x05: PhraseKindVerse2,
+		0x06: PhraseKindVerse2,
+		0x07: PhraseKindVerse2,
+		0x08: PhraseKindBridge,
+		0x09: PhraseKindChorus,
+		0x0a: PhraseKindOutro,
+	},
+}
+
+// phraseBeatsPerBar is the number of beats in each bar. The phrase analysis
+// always assumes 4/4 time.
+const phraseBeatsPerBar = 4
+
+// Phrase is a single section of a track as determined by the phrase analysis
+// used by rekordbox lighting mode.
+type Phrase struct {
+	Mood PhraseMood
+	Kind PhraseKind
+
+	// StartBeat is the beat number the phrase begins on, the first beat of the
+	// track being beat 1. EndBeat is the beat number the next phrase begins
+	// on.
+	StartBeat int
+	EndBeat   int
+
+	// StartBar and EndBar are the bar numbers the phrase begins and ends on,
+	// the first bar of the track being bar 1.
+	StartBar int
+	EndBar   int
+
+	// FillBeat is the beat number a fill-in begins on near the end of the
+	// phrase. Phrases without a fill-in have a FillBeat of 0.
+	FillBeat int
+}
+
+// Offsets of the fields within the PSSI song structure analysis tag.
+const (
+	phraseEntryLenOffset   = 0x0c
+	phraseCountOffset      = 0x10
+	phraseMoodOffset       = 0x12
+	phraseEndBeatOffset    = 0x1a
+	phraseHeaderLen        = 0x20
+	phraseEntryBeat        = 0x02
+	phraseEntryKind        = 0x04
+	phraseEntryFill        = 0x15
+	phraseEntryFillBeat    = 0x16
+	phraseEntryMinimumSize = 0x18
+)
+
+// phraseMask is the mask newer versions of rekordbox XOR the song structure
+// analysis with, starting at the mood field. Each byte of the mask is offset
+// by the number of phrases.
+var phraseMask = []byte{
+	0xcb, 0xe1, 0xee, 0xfa, 0xe5, 0xee, 0xad, 0xee, 0xe9, 0xd2,
+	0xe9, 0xeb, 0xe1, 0xe9, 0xf3, 0xe8, 0xe9, 0xf4, 0xe1,
+}
+
+// GetPhrases queries the remote db for the phrase analysis of a track. An
+// empty list is returned if the track has not had its phrases analyzed.
+func (rd *RemoteDB) GetPhrases(q *TrackQuery) ([]Phrase, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if !rd.supports(q.DeviceID, featureAnlzTag) {
+		return nil, ErrRequestUnsupported
+	}
+
+	data, err := rd.getAnlzTag(q, anlzTagSongStructure, anlzFileExtended)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePhrases(data), nil
+}
+
+// parsePhrases parses the PSSI song structure analysis tag.
+func parsePhrases(data []byte) []Phrase {
+	if len(data) < phraseHeaderLen {
+		return []Phrase{}
+	}
+
+	count := int(be.Uint16(data[phraseCountOffset:]))
+	entryLen := int(be.Uint32(data[phraseEntryLenOffset:]))
+
+	// Masked analysis will not have a known mood
+	if _, ok := phraseMoodLabels[PhraseMood(be.Uint16(data[phraseMoodOffset:]))]; !ok {
+		data = append([]byte{}, data...)
+
+		for i := range data[phraseMoodOffset:] {
+			data[phraseMoodOffset+i] ^= phraseMask[i%len(phraseMask)] + byte(count)
+		}
+	}
+
+	mood := PhraseMood(be.Uint16(data[phraseMoodOffset:]))
+	endBeat := int(be.Uint16(data[phraseEndBeatOffset:]))
+
+	if entryLen < phraseEntryMinimumSize {
+		return []Phrase{}
+	}
+
+	entries := data[be.Uint32(data[anlzTagHeaderLenOffset:]):]
+	phrases := make([]Phrase, 0, count)
+
+	for i := 0; i < count && len(entries) >= entryLen; i++ {
+		entry := entries[:entryLen]
+		entries = entries[entryLen:]
+
+		phrase := Phrase{
+			Mood:      mood,
+			Kind:      phraseKinds[mood][be.Uint16(entry[phraseEntryKind:])],
+			StartBeat: int(be.Uint16(entry[phraseEntryBeat:])),
+		}
+
+		if entry[phraseEntryFill] != 0 {
+			phrase.FillBeat = int(be.Uint16(entry[phraseEntryFillBeat:]))
+		}
+
+		phrases = append(phrases, phrase)
+	}
+
+	// Each phrase ends where the following phrase begins, the last phrase
+	// ending at the end of the track's phrase analysis.
+	for i := range phrases {
+		phrases[i].EndBeat = endBeat
+
+		if i+1 < len(phrases) {
+			phrases[i].EndBeat = phrases[i+1].StartBeat
+		}
+
+		phrases[i].StartBar = (phrases[i].StartBeat-1)/phraseBeatsPerBar + 1
+		phrases[i].EndBar = (phrases[i].EndBeat-1)/phraseBeatsPerBar + 1
+	}
+
+	return phrases
+}
@@ -2,11 +2,14 @@ package prolink
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,9 +18,21 @@ import (
 // not currently 'linked' on the network.
 var ErrDeviceNotLinked = fmt.Errorf("The device is not linked on the network")
 
-// ErrCDUnsupported is returned when attempting to read metadata from a CD slot.
-// TODO: Figure out what packet sequence is needed to read CD metadata.
-var ErrCDUnsupported = fmt.Errorf("Reading metadata from CDs is currently unsupported")
+// ErrCDUnsupported is returned when attempting to browse the CD slot.
+var ErrCDUnsupported = fmt.Errorf("Browsing CDs is currently unsupported")
+
+// ErrQueueFull is returned by RemoteDB when the number of queries waiting on
+// a device has reached the MaxQueuedRequests of its LinkPolicy.
+var ErrQueueFull = fmt.Errorf("Too many queries are waiting on the device")
+
+// ErrInvalidRequest is returned by RemoteDB when the device responds that the
+// requested data is unavailable, typically because the request is not valid
+// for the media in the slot.
+var ErrInvalidRequest = fmt.Errorf("The device rejected the request as invalid")
+
+// ErrTrackNotFound is returned by RemoteDB when the queried track does not
+// exist on the media in the slot.
+var ErrTrackNotFound = fmt.Errorf("The track does not exist on the device")
 
 // allowedDevices specify what device types act as a remote DB server
 var allowedDevices = map[DeviceType]bool{
@@ -25,16 +40,62 @@ var allowedDevices = map[DeviceType]bool{
 	DeviceTypeCDJ: true,
 }
 
+// menuBatchSize is the number of menu items that will be requested to be
+// rendered at once. Players do not appear to like rendering large menus in a
+// single request.
+const menuBatchSize = 64
+
+// menuNoResults is the item count of a menu response when the request has no
+// results, such as metadata requested for a track that does not exist.
+const menuNoResults = 0xffffffff
+
 // rbDBServerQueryPort is the consistent port on which we can query the remote
 // db server for the port to connect to to communicate with it.
 const rbDBServerQueryPort = 12523
 
+// LinkPolicy configures how the RemoteDB links to the database server of a
+// device.
+type LinkPolicy struct {
+	// RetryEvery specifies how long to wait between attempts to connect to a
+	// device whose database server is not available.
+	RetryEvery time.Duration
+
+	// DialTimeout specifies how long to wait for a connection to the devices
+	// database server to be established.
+	DialTimeout time.Duration
+
+	// KeepaliveInterval specifies how long a connection to the devices
+	// database server may sit idle before a message is sent to keep the
+	// device from dropping the connection. A zero interval disables the
+	// keepalive.
+	KeepaliveInterval time.Duration
+
+	// RequestInterval specifies the minimum time between the start of each
+	// query made to the device. Players may become unstable when flooded with
+	// queries, bulk operations such as listing an entire collection may be
+	// slowed down to avoid this. A zero interval does not limit queries.
+	RequestInterval time.Duration
+
+	// MaxQueuedRequests specifies the maximum number of queries that may be
+	// in progress or waiting on the device at once. Further queries fail
+	// immediately with ErrQueueFull. Zero does not limit the queue.
+	MaxQueuedRequests int
+}
+
+// defaultLinkPolicy is the LinkPolicy used for device types that have not been
+// configured using SetLinkPolicy.
+var defaultLinkPolicy = LinkPolicy{
+	RetryEvery:        5 * time.Second,
+	DialTimeout:       5 * time.Second,
+	KeepaliveInterval: 30 * time.Second,
+}
+
 // getRemoteDBServerAddr queries the remote device for the port that the remote
 // database server is listening on for requests.
-func getRemoteDBServerAddr(deviceIP net.IP) (string, error) {
+func getRemoteDBServerAddr(deviceIP net.IP, timeout time.Duration) (string, error) {
 	addr := fmt.Sprintf("%s:%d", deviceIP, rbDBServerQueryPort)
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
 	if err != nil {
 		return "", err
 	}
@@ -58,7 +119,7 @@ func getRemoteDBServerAddr(deviceIP net.IP) (string, error) {
 	// Read request response, should be a two byte uint16
 	data := make([]byte, 2)
 
-	_, err = conn.Read(data)
+	_, err = io.ReadFull(conn, data)
 	if err != nil {
 		return "", fmt.Errorf("Failed to retrieve remote DB Server port: %s", err)
 	}
@@ -68,35 +129,75 @@ func getRemoteDBServerAddr(deviceIP net.IP) (string, error) {
 	return fmt.Sprintf("%s:%d", deviceIP, port), nil
 }
 
+// deviceConnection is the connection to the database server of a single
+// device. The lock is held for the duration of each request and its responses,
+// so that concurrent queries do not interleave on the connection. The conn and
+// features are guarded separately by the connLock, so that the connection may
+// be closed while a request is blocked on it.
 type deviceConnection struct {
 	remoteDB *RemoteDB
 	device   *Device
 	lock     *sync.Mutex
-	conn     net.Conn
 	txCount  uint32
 
-	retryEvery time.Duration
+	connLock *sync.Mutex
+	conn     net.Conn
+
+	// lastActive is the time a message was last sent to the device. It is
+	// guarded by the connLock.
+	lastActive time.Time
+
+	// lastRequest is the time the last query was started. queue holds a value
+	// for each query in progress or waiting when the queue depth is limited.
+	lastRequest time.Time
+	queue       chan bool
+
+	policy     LinkPolicy
+	features   map[remoteDBFeature]bool
 	disconnect chan bool
 }
 
-// connect attempts to open a TCP socket connection to the device. This will
-// send the necessary packet sequence in order start communicating with the
-// database server once connected.
+// connect attempts to open a TCP socket connection to the device. Once
+// connected the requests supported by the device are probed.
 func (dc *deviceConnection) connect() error {
-	addr, err := getRemoteDBServerAddr(dc.device.IP)
+	conn, err := dc.dial()
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	conn, features, err := dc.probeFeatures(conn)
 	if err != nil {
 		return err
 	}
 
+	dc.connLock.Lock()
+	dc.features = features
+	dc.conn = conn
+	dc.lastActive = time.Now()
+	dc.connLock.Unlock()
+
+	return nil
+}
+
+// dial opens a TCP socket connection to the device. This will send the
+// necessary packet sequence in order start communicating with the database
+// server once connected.
+func (dc *deviceConnection) dial() (net.Conn, error) {
+	addr, err := getRemoteDBServerAddr(dc.device.IP, dc.policy.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, dc.policy.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
 	// Begin connection to the remote database
 	preamble := fieldNumber04(0x01)
 	if _, err = conn.Write(preamble.bytes()); err != nil {
-		return fmt.Errorf("Failed to connect to remote database: %s", err)
+		conn.Close()
+		return nil, fmt.Errorf("Failed to connect to remote database: %s", err)
 	}
 
 	// No need to keep this response, but it should be a uin32 field, which is
@@ -104,22 +205,121 @@ func (dc *deviceConnection) connect() error {
 	io.CopyN(ioutil.Discard, conn, 5)
 
 	introPacket := &introducePacket{
-		deviceID: dc.remoteDB.deviceID,
+		deviceID: dc.remoteDB.requestingDeviceID(),
 	}
 
 	if _, err = conn.Write(introPacket.bytes()); err != nil {
-		return fmt.Errorf("Failed to connect to remote database: %s", err)
+		conn.Close()
+		return nil, fmt.Errorf("Failed to connect to remote database: %s", err)
 	}
 
 	if _, err := readMessagePacket(conn); err != nil {
-		return err
+		conn.Close()
+		return nil, err
 	}
 
-	dc.conn = conn
+	return conn, nil
+}
+
+// socket returns the open connection to the device, or nil if the device is
+// not connected.
+func (dc *deviceConnection) socket() net.Conn {
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	return dc.conn
+}
+
+// closeSocket closes the open connection to the device, if any.
+func (dc *deviceConnection) closeSocket() {
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	if dc.conn != nil {
+		dc.conn.Close()
+		dc.conn = nil
+	}
+}
+
+// watchContext applies the deadline of the context to the connection, and
+// aborts any read or write on the connection when the context is canceled.
+// The returned function must be called once the request is complete, the
+// connection lock should be held until then.
+func (dc *deviceConnection) watchContext(ctx context.Context) func() {
+	conn := dc.socket()
+
+	if ctx.Done() == nil || conn == nil {
+		return func() {}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			// A deadline in the past aborts blocked reads and writes
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		conn.SetDeadline(time.Time{})
+	}
+}
+
+// acquire waits for the turn of a query on the connection, after which the
+// query may be made until release is called. Queries are spaced out by the
+// request interval of the link policy.
+func (dc *deviceConnection) acquire() error {
+	if dc.queue != nil {
+		select {
+		case dc.queue <- true:
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	dc.lock.Lock()
+
+	if wait := time.Until(dc.lastRequest.Add(dc.policy.RequestInterval)); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	dc.lastRequest = time.Now()
 
 	return nil
 }
 
+// release ends the query started with acquire.
+func (dc *deviceConnection) release() {
+	dc.lock.Unlock()
+
+	if dc.queue != nil {
+		<-dc.queue
+	}
+}
+
+// reconnect closes the connection to the device and immediately connects
+// again, rediscovering the port of the database server.
+func (dc *deviceConnection) reconnect() error {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	dc.closeSocket()
+
+	return dc.connect()
+}
+
 func (dc *deviceConnection) tryConnect(ticker *time.Ticker) bool {
 	select {
 	case <-dc.disconnect:
@@ -130,13 +330,12 @@ func (dc *deviceConnection) tryConnect(ticker *time.Ticker) bool {
 }
 
 func (dc *deviceConnection) ensureConnect() {
-	dc.disconnect = make(chan bool, 1)
-	ticker := time.NewTicker(dc.retryEvery)
+	ticker := time.NewTicker(dc.policy.RetryEvery)
 
 	// Attempt to immediately connect
 	dc.connect()
 
-	for dc.conn == nil && !dc.tryConnect(ticker) {
+	for dc.socket() == nil && !dc.tryConnect(ticker) {
 	}
 
 	ticker.Stop()
@@ -145,7 +344,99 @@ func (dc *deviceConnection) ensureConnect() {
 // Open begins attempting to connect to the device. If we're unable to connect
 // to the device we will retry until the deviceConnection is closed.
 func (dc *deviceConnection) Open() {
+	dc.disconnect = make(chan bool, 1)
+
 	go dc.ensureConnect()
+	go dc.keepalive(dc.disconnect)
+}
+
+// keepalive pings the device whenever the connection has been idle for the
+// keepalive interval, until the deviceConnection is closed. Should a ping
+// fail the connection is refreshed.
+func (dc *deviceConnection) keepalive(disconnect chan bool) {
+	if dc.policy.KeepaliveInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(dc.policy.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-disconnect:
+			return
+		case <-ticker.C:
+		}
+
+		if err := dc.ping(); err != nil {
+			dc.remoteDB.refreshConnection(dc)
+			return
+		}
+	}
+}
+
+// ping requests the track menu of the USB slot from an idle database server,
+// without rendering any of its items. This is the same request made when
+// browsing the track list, answered with a single response carrying the
+// number of items in the menu, making it a harmless way to keep the
+// connection alive. Any response with the transaction ID of the request is
+// accepted, as the slot may be empty.
+func (dc *deviceConnection) ping() error {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	conn := dc.socket()
+
+	if conn == nil || dc.idle() < dc.policy.KeepaliveInterval {
+		return nil
+	}
+
+	dc.touch()
+
+	conn.SetDeadline(time.Now().Add(dc.policy.DialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	menuRequest := &menuRequestPacket{
+		messageType: msgTypeMenuTrack,
+		deviceID:    dc.remoteDB.requestingDeviceID(),
+		slot:        TrackSlotUSB,
+	}
+
+	txID := dc.txCount
+	menuRequest.setTransactionID(txID)
+
+	if _, err := conn.Write(menuRequest.bytes()); err != nil {
+		return err
+	}
+
+	dc.txCount++
+
+	resp, err := readMessagePacket(conn)
+	if err != nil {
+		return err
+	}
+
+	if resp.transaction != txID {
+		return &transactionError{expected: txID, actual: resp.transaction}
+	}
+
+	return nil
+}
+
+// touch records that a message was sent to the device.
+func (dc *deviceConnection) touch() {
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	dc.lastActive = time.Now()
+}
+
+// idle returns how long it has been since a message was sent to the device.
+func (dc *deviceConnection) idle() time.Duration {
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	return time.Since(dc.lastActive)
 }
 
 // Close stops any attempts to connect to the device or closes any open socket
@@ -156,26 +447,75 @@ func (dc *deviceConnection) Close() {
 		close(dc.disconnect)
 	}
 
-	if dc.conn != nil {
-		dc.conn.Close()
-		dc.conn = nil
-	}
+	dc.closeSocket()
+}
+
+// Track color labels
+const (
+	TrackColorNone   TrackColor = 0x00
+	TrackColorPink   TrackColor = 0x01
+	TrackColorRed    TrackColor = 0x02
+	TrackColorOrange TrackColor = 0x03
+	TrackColorYellow TrackColor = 0x04
+	TrackColorGreen  TrackColor = 0x05
+	TrackColorAqua   TrackColor = 0x06
+	TrackColorBlue   TrackColor = 0x07
+	TrackColorPurple TrackColor = 0x08
+)
+
+// Labels associated to the track colors
+var trackColorLabels = map[TrackColor]string{
+	TrackColorNone:   "none",
+	TrackColorPink:   "pink",
+	TrackColorRed:    "red",
+	TrackColorOrange: "orange",
+	TrackColorYellow: "yellow",
+	TrackColorGreen:  "green",
+	TrackColorAqua:   "aqua",
+	TrackColorBlue:   "blue",
+	TrackColorPurple: "purple",
+}
+
+// TrackColor represents the color label assigned to a track in rekordbox.
+type TrackColor byte
+
+// String returns the string representation of the track color.
+func (c TrackColor) String() string {
+	return trackColorLabels[c]
 }
 
 // Track contains track information retrieved from the remote database.
 type Track struct {
-	ID        uint32
-	Path      string
-	Title     string
-	Artist    string
-	Album     string
-	Label     string
-	Genre     string
-	Comment   string
-	Key       string
-	Length    time.Duration
-	DateAdded time.Time
-	Artwork   []byte
+	ID             uint32
+	Path           string
+	Title          string
+	Artist         string
+	Album          string
+	Remixer        string
+	OriginalArtist string
+	Label          string
+	Genre          string
+	Comment        string
+	Key            string
+	BPM            float64
+	Rating         int
+	Color          TrackColor
+	Year           int
+	Length         time.Duration
+	DateAdded      time.Time
+	Artwork        []byte
+
+	// BitRate is the bit rate of the audio file in kbps.
+	BitRate int
+
+	// FileType is the type of audio file (MP3, FLAC, etc) as determined from
+	// the extension of the track Path.
+	FileType string
+
+	// IsAnalyzed reports that the track was found in the rekordbox database.
+	// Tracks on CDs and media not analyzed by rekordbox only have their Title,
+	// Artist, Length, and Comment available.
+	IsAnalyzed bool
 }
 
 // TrackQuery is used to make queries for track metadata.
@@ -189,60 +529,363 @@ type TrackQuery struct {
 	artworkID uint32
 }
 
-// RemoteDB provides an interface to talking to the remote database.
+// RemoteDB provides an interface to talking to the remote database. Queries
+// may be made from multiple goroutines, queries to the same device are
+// executed one at a time.
 type RemoteDB struct {
 	deviceID  DeviceID
 	conns     map[DeviceID]*deviceConnection
 	connsLock *sync.Mutex
+	warner    *protocolWarner
+
+	// subscriptions are the device listeners registered while active.
+	subscriptions []*DeviceSubscription
+
+	artworkConfig  ArtworkConfig
+	artworkCache   *artworkCache
+	trackCache     *trackCache
+	pathIndex      *pathIndex
+	linkPolicies   map[DeviceType]LinkPolicy
+	spillThreshold int
+}
+
+// SetArtworkConfig configures how artwork returned by the remote database is
+// transcoded. This allows artwork to always be returned in a consistent format
+// and size regardless of what was imported into rekordbox.
+func (rd *RemoteDB) SetArtworkConfig(config ArtworkConfig) {
+	rd.connsLock.Lock()
+	rd.artworkConfig = config
+	rd.connsLock.Unlock()
+
+	// Artwork transcoded using the previous configuration is removed, along
+	// with the cached tracks it was returned with
+	rd.artworkCache.remove(func(artworkKey) bool { return true })
+	rd.trackCache.remove(func(key trackKey) bool { return !key.metadataOnly })
+}
+
+// getArtworkConfig returns the configured ArtworkConfig.
+func (rd *RemoteDB) getArtworkConfig() ArtworkConfig {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	return rd.artworkConfig
+}
+
+// SetArtworkCacheSize configures the number of bytes of artwork that will be
+// held in memory. Many tracks share the same artwork, so caching avoids
+// transferring the same artwork from the device repeatedly. The least recently
+// used artwork is removed once the cache is full. A size of zero disables the
+// cache.
+func (rd *RemoteDB) SetArtworkCacheSize(size int) {
+	rd.artworkCache.resize(size)
+}
+
+// SetLinkPolicy configures the LinkPolicy used when linking to devices of the
+// given type. For example rekordbox running on a laptop over Wi-Fi may come
+// and go frequently, and can be retried less aggressively than CDJs.
+//
+// The policy is applied to devices linked after it is set. Zero values in the
+// policy fall back to the default policy values.
+func (rd *RemoteDB) SetLinkPolicy(devType DeviceType, policy LinkPolicy) {
+	if policy.RetryEvery == 0 {
+		policy.RetryEvery = defaultLinkPolicy.RetryEvery
+	}
+
+	if policy.DialTimeout == 0 {
+		policy.DialTimeout = defaultLinkPolicy.DialTimeout
+	}
+
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	rd.linkPolicies[devType] = policy
+}
+
+// linkPolicy returns the LinkPolicy used when linking to devices of the given
+// type.
+func (rd *RemoteDB) linkPolicy(devType DeviceType) LinkPolicy {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	if policy, ok := rd.linkPolicies[devType]; ok {
+		return policy
+	}
+
+	return defaultLinkPolicy
+}
+
+// SetSpillThreshold configures the number of items a browse menu may contain
+// before the items are written to a temporary file rather than held in
+// memory. This applies to queries returning a BrowseIterator, such as
+// IterateTracks, allowing very large collections to be listed without holding
+// every track in memory. A threshold of zero disables spilling to disk.
+func (rd *RemoteDB) SetSpillThreshold(items int) {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	rd.spillThreshold = items
+}
+
+// getSpillThreshold returns the configured spill threshold.
+func (rd *RemoteDB) getSpillThreshold() int {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	return rd.spillThreshold
 }
 
 // IsLinked reports weather the DB server is available for the given device.
 func (rd *RemoteDB) IsLinked(devID DeviceID) bool {
-	devConn, ok := rd.conns[devID]
+	devConn := rd.conn(devID)
 
-	return ok && devConn.conn != nil
+	return devConn != nil && devConn.socket() != nil
 }
 
-// GetTrack queries the remote db for track details given a track ID.
+// conn returns the deviceConnection for the device, or nil if there is no
+// connection for the device.
+func (rd *RemoteDB) conn(devID DeviceID) *deviceConnection {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	return rd.conns[devID]
+}
+
+// GetTrack queries the remote db for track details given a track ID. Tracks
+// are cached until the media they were read from is unmounted, so repeated
+// calls for the same track do not query the device. ErrTrackNotFound is
+// returned if the track does not exist on the media, and ErrInvalidRequest if
+// the device rejects the query.
 func (rd *RemoteDB) GetTrack(q *TrackQuery) (*Track, error) {
+	return rd.GetTrackContext(context.Background(), q)
+}
+
+// GetTrackContext is like GetTrack, but the query is aborted when the context
+// is canceled or its deadline is reached. Should the query be aborted the
+// connection to the device is re-established, and the context error is
+// returned.
+func (rd *RemoteDB) GetTrackContext(ctx context.Context, q *TrackQuery) (*Track, error) {
+	return rd.getTrack(ctx, q, false)
+}
+
+// GetTrackMetadata queries the remote db for the metadata of a track, such as
+// the title and artist, without querying for the track Path, FileType, or
+// Artwork. This requires less than half the round trips to the device of
+// GetTrack, making it suitable for applications only displaying the track
+// details. Tracks are cached in the same way as GetTrack, and tracks already
+// cached by GetTrack are returned from the cache.
+func (rd *RemoteDB) GetTrackMetadata(q *TrackQuery) (*Track, error) {
+	return rd.GetTrackMetadataContext(context.Background(), q)
+}
+
+// GetTrackMetadataContext is like GetTrackMetadata, but the query is aborted
+// when the context is canceled or its deadline is reached, as with
+// GetTrackContext.
+func (rd *RemoteDB) GetTrackMetadataContext(ctx context.Context, q *TrackQuery) (*Track, error) {
+	return rd.getTrack(ctx, q, true)
+}
+
+// getTrack queries for the track, returning it from the track cache when it
+// has been cached. When metadataOnly is set a complete track from the cache
+// may be returned.
+func (rd *RemoteDB) getTrack(ctx context.Context, q *TrackQuery, metadataOnly bool) (*Track, error) {
 	if !rd.IsLinked(q.DeviceID) {
 		return nil, ErrDeviceNotLinked
 	}
 
-	if q.Slot == TrackSlotCD {
-		return nil, ErrCDUnsupported
+	if !rd.supports(q.DeviceID, featureMetadata) {
+		return nil, ErrRequestUnsupported
 	}
 
-	track, err := rd.executeQuery(q)
+	key := trackKey{
+		deviceID:     q.DeviceID,
+		slot:         q.Slot,
+		trackID:      q.TrackID,
+		metadataOnly: metadataOnly,
+	}
 
-	// Refresh the connection if we EOF while querying the server
-	if err != nil && err == io.EOF {
-		rd.refreshConnection(rd.conns[q.DeviceID].device)
+	// The metadata of a complete track is already known
+	if metadataOnly {
+		if track := rd.cachedTrack(q); track != nil {
+			return track, nil
+		}
 	}
 
-	return track, err
+	if track, ok := rd.trackCache.get(key); ok {
+		return track, nil
+	}
+
+	var track *Track
+
+	err := rd.retryOnDisconnect(q.DeviceID, func() (err error) {
+		track, err = rd.executeQuery(ctx, q, metadataOnly)
+		return err
+	})
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rd.trackCache.add(key, track)
+
+	return track, nil
 }
 
-func (rd *RemoteDB) executeQuery(q *TrackQuery) (*Track, error) {
+// TrackResult is the result of querying a single track of GetTracks.
+type TrackResult struct {
+	TrackID uint32
+	Track   *Track
+	Err     error
+}
+
+// GetTracks queries the remote db for the details of multiple tracks on the
+// media in the slot of the device. Each result is sent on the returned channel
+// as soon as it is available, the channel is closed once every track has been
+// queried. Tracks that have already been cached are sent first, without
+// waiting on the device.
+//
+// The queries for each track cannot be pipelined, as a render request always
+// renders the menu most recently requested on the connection. Uncached tracks
+// are queried in turn over the connection to the device, other queries to the
+// device may be made between them.
+func (rd *RemoteDB) GetTracks(deviceID DeviceID, slot TrackSlot, ids []uint32) <-chan *TrackResult {
+	results := make(chan *TrackResult, len(ids))
+	queries := make([]*TrackQuery, 0, len(ids))
+
+	for _, id := range ids {
+		q := &TrackQuery{
+			DeviceID: deviceID,
+			Slot:     slot,
+			TrackID:  id,
+		}
+
+		if track := rd.cachedTrack(q); track != nil {
+			results <- &TrackResult{TrackID: id, Track: track}
+			continue
+		}
+
+		queries = append(queries, q)
+	}
+
+	go func() {
+		defer close(results)
+
+		for _, q := range queries {
+			track, err := rd.GetTrack(q)
+
+			results <- &TrackResult{TrackID: q.TrackID, Track: track, Err: err}
+		}
+	}()
+
+	return results
+}
+
+// cachedTrack returns the complete track if it has been cached, otherwise nil.
+func (rd *RemoteDB) cachedTrack(q *TrackQuery) *Track {
+	track, _ := rd.trackCache.get(trackKey{
+		deviceID: q.DeviceID,
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+	})
+
+	return track
+}
+
+// removeCached removes cached tracks, artwork, and path indexes read from the
+// media slots matching the filter.
+func (rd *RemoteDB) removeCached(filter func(DeviceID, TrackSlot) bool) {
+	rd.trackCache.remove(func(k trackKey) bool { return filter(k.deviceID, k.slot) })
+	rd.artworkCache.remove(func(k artworkKey) bool { return filter(k.deviceID, k.slot) })
+	rd.pathIndex.remove(func(k mediaKey) bool { return filter(k.deviceID, k.slot) })
+}
+
+// invalidateMedia removes cached tracks and artwork read from the USB or SD
+// slot of a player once the player reports the media has been unmounted.
+func (rd *RemoteDB) invalidateMedia(s *CDJStatus) {
+	loaded := map[TrackSlot]bool{
+		TrackSlotUSB: s.IsUSBLoaded,
+		TrackSlotSD:  s.IsSDLoaded,
+	}
+
+	for slot, isLoaded := range loaded {
+		key := mediaKey{deviceID: s.PlayerID, slot: slot}
+
+		if !rd.trackCache.setMounted(key, isLoaded) {
+			continue
+		}
+
+		rd.removeCached(func(devID DeviceID, cachedSlot TrackSlot) bool {
+			return devID == key.deviceID && cachedSlot == key.slot
+		})
+	}
+}
+
+// executeQuery queries for the track. When metadataOnly is set the path and
+// artwork of the track are not queried.
+func (rd *RemoteDB) executeQuery(ctx context.Context, q *TrackQuery, metadataOnly bool) (*Track, error) {
 	// Synchroize queries as not to distruct the query flow. We could probably
 	// be a little more precice about where the locks are, but for now the
 	// entire query is pretty fast, just lock the whole thing.
-	rd.conns[q.DeviceID].lock.Lock()
-	defer rd.conns[q.DeviceID].lock.Unlock()
+	devConn := rd.conn(q.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if err := devConn.acquire(); err != nil {
+		return nil, err
+	}
+
+	stopWatching := devConn.watchContext(ctx)
+	track, err := rd.queryTrack(devConn, q, metadataOnly)
+	stopWatching()
+
+	devConn.release()
+
+	// The connection is left mid-response if the query was aborted
+	if ctx.Err() != nil {
+		rd.refreshConnection(devConn)
+		return nil, ctx.Err()
+	}
+
+	return track, err
+}
+
+// queryTrack queries for the track on the connection. The lock of the
+// deviceConnection must be held.
+func (rd *RemoteDB) queryTrack(devConn *deviceConnection, q *TrackQuery, metadataOnly bool) (*Track, error) {
+	track, err := rd.queryTrackMetadata(devConn, q, q.Slot == TrackSlotCD)
+
+	// Tracks on media that has not been analyzed by rekordbox do not exist in
+	// the rekordbox database. Fallback to the unanalyzed metadata.
+	if isUnexpectedResponse(err) || err == ErrTrackNotFound {
+		track, err = rd.queryTrackMetadata(devConn, q, true)
+	}
+
+	if isUnexpectedResponse(err) {
+		return nil, ErrTrackNotFound
+	}
 
-	track, err := rd.queryTrackMetadata(q)
 	if err != nil {
 		return nil, err
 	}
 
-	path, err := rd.queryTrackPath(q)
+	// Unanalyzed tracks have no path or artwork
+	if metadataOnly || !track.IsAnalyzed {
+		return track, nil
+	}
+
+	path, err := rd.queryTrackPath(devConn, q)
 	if err != nil {
 		return nil, err
 	}
 
 	track.Path = path
+	track.FileType = strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), "."))
 
-	artwork, err := rd.getArtwork(q)
+	artwork, err := rd.getCachedArtwork(devConn, q)
 	if err != nil {
 		return nil, err
 	}
@@ -254,71 +897,100 @@ func (rd *RemoteDB) executeQuery(q *TrackQuery) (*Track, error) {
 
 // queryTrackMetadata queries the rmote database for various metadata about a
 // track, returing a sparse Track object. The track Path and Artwork must be
-// looked up as separate queries.
+// looked up as separate queries. Unanalyzed metadata only includes the title,
+// artist, duration, and comment of the track.
 //
 // Note that the Artwork ID is populated into the passed TrackQuery after this
 // call completes.
-func (rd *RemoteDB) queryTrackMetadata(q *TrackQuery) (*Track, error) {
+func (rd *RemoteDB) queryTrackMetadata(dc *deviceConnection, q *TrackQuery, unanalyzed bool) (*Track, error) {
 	trackID := make([]byte, 4)
 	binary.BigEndian.PutUint32(trackID, q.TrackID)
 
 	getMetadata := &metadataRequestPacket{
-		deviceID: rd.deviceID,
-		slot:     q.Slot,
-		trackID:  q.TrackID,
+		deviceID:   rd.requestingDeviceID(),
+		slot:       q.Slot,
+		trackID:    q.TrackID,
+		unanalyzed: unanalyzed,
 	}
 
 	renderData := &renderRequestPacket{
-		deviceID: rd.deviceID,
+		deviceID: rd.requestingDeviceID(),
 		slot:     q.Slot,
-		offset:   0,
-		limit:    32,
 	}
 
-	items, err := rd.getMenuItems(q.DeviceID, getMetadata, renderData)
+	items, err := rd.getMenuItems(dc, getMetadata, renderData)
 	if err != nil {
 		return nil, err
 	}
 
-	q.artworkID = items[itemTypeTitle].artworkID
+	if len(items) == 0 {
+		return nil, ErrTrackNotFound
+	}
+
+	if title, ok := items[itemTypeTitle]; ok {
+		q.artworkID = title.artworkID
+	}
 
 	duration := time.Duration(items.getNum(itemTypeDuration)) * time.Second
 
+	// The tempo is encoded as a fixed-point number, 100 times the BPM
+	bpm := float64(items.getNum(itemTypeTempo)) / 100
+
+	// The date added is left as the zero time if it is missing or malformed
+	dateAdded, _ := time.Parse(dateAddedLayout, items.getText(itemTypeDateAdded))
+
+	// The color is not a field of the color menu item, but is instead
+	// represented by the item type of the color menu item.
+	color := TrackColorNone
+
+	for itemType := byte(itemTypeColorNone); itemType <= itemTypeColorPurple; itemType++ {
+		if _, ok := items[itemType]; ok {
+			color = TrackColor(itemType - itemTypeColorNone)
+		}
+	}
+
 	track := &Track{
-		ID:      q.TrackID,
-		Title:   items.getText(itemTypeTitle),
-		Artist:  items.getText(itemTypeArtist),
-		Album:   items.getText(itemTypeAlbum),
-		Comment: items.getText(itemTypeComment),
-		Key:     items.getText(itemTypeKey),
-		Genre:   items.getText(itemTypeGenre),
-		Label:   items.getText(itemTypeLabel),
-		Length:  duration,
+		ID:             q.TrackID,
+		Title:          items.getText(itemTypeTitle),
+		Artist:         items.getText(itemTypeArtist),
+		Album:          items.getText(itemTypeAlbum),
+		Remixer:        items.getText(itemTypeRemixer),
+		OriginalArtist: items.getText(itemTypeOriginalArtist),
+		Comment:        items.getText(itemTypeComment),
+		Key:            items.getText(itemTypeKey),
+		Genre:          items.getText(itemTypeGenre),
+		Label:          items.getText(itemTypeLabel),
+		BPM:            bpm,
+		Rating:         items.getNum(itemTypeRating),
+		Color:          color,
+		Year:           items.getNum(itemTypeYear),
+		BitRate:        items.getNum(itemTypeBitRate),
+		Length:         duration,
+		DateAdded:      dateAdded,
+		IsAnalyzed:     !unanalyzed,
 	}
 
 	return track, nil
 }
 
 // queryTrackPath looks up the file path of a track in rekordbox.
-func (rd *RemoteDB) queryTrackPath(q *TrackQuery) (string, error) {
+func (rd *RemoteDB) queryTrackPath(dc *deviceConnection, q *TrackQuery) (string, error) {
 	trackID := make([]byte, 4)
 	binary.BigEndian.PutUint32(trackID, q.TrackID)
 
 	trackInfoRequest := &trackInfoRequestPacket{
-		deviceID: rd.deviceID,
+		deviceID: rd.requestingDeviceID(),
 		slot:     q.Slot,
 		trackID:  q.TrackID,
 	}
 
 	renderRequest := &renderRequestPacket{
 		renderType: renderSystem,
-		deviceID:   rd.deviceID,
+		deviceID:   rd.requestingDeviceID(),
 		slot:       q.Slot,
-		offset:     0,
-		limit:      32,
 	}
 
-	items, err := rd.getMenuItems(q.DeviceID, trackInfoRequest, renderRequest)
+	items, err := rd.getMenuItems(dc, trackInfoRequest, renderRequest)
 	if err != nil {
 		return "", err
 	}
@@ -328,79 +1000,423 @@ func (rd *RemoteDB) queryTrackPath(q *TrackQuery) (string, error) {
 
 // getMenuItems is used to query a list of menu items. It returns a mapping of
 // the menu itemType byte to the menu item packet object.
-func (rd *RemoteDB) getMenuItems(devID DeviceID, p1, p2 messagePacket) (menuItems, error) {
-	if err := rd.sendMessage(devID, p1); err != nil {
+func (rd *RemoteDB) getMenuItems(dc *deviceConnection, p1 messagePacket, p2 *renderRequestPacket) (menuItems, error) {
+	list, err := rd.getMenuItemList(dc, p1, p2)
+	if err != nil {
 		return nil, err
 	}
 
-	resp, err := readMessagePacket(rd.conns[devID].conn)
+	items := map[byte]*menuItem{}
+
+	for _, item := range list {
+		items[item.itemType] = item
+	}
+
+	return menuItems(items), nil
+}
+
+// getMenuItemList requests a menu using the request packet and renders every
+// item of the menu, in the order they are returned by the remote database.
+func (rd *RemoteDB) getMenuItemList(dc *deviceConnection, p1 messagePacket, p2 *renderRequestPacket) ([]*menuItem, error) {
+	items := []*menuItem{}
+
+	err := rd.eachMenuItem(dc, p1, p2, func(item *menuItem) error {
+		items = append(items, item)
+		return nil
+	})
+
+	return items, err
+}
+
+// eachMenuItem requests a menu using the request packet and renders every
+// item of the menu, calling fn with each item as it is received. Items are
+// rendered in batches of menuBatchSize, the offset and limit of the render
+// packet will be filled in for each batch.
+func (rd *RemoteDB) eachMenuItem(dc *deviceConnection, p1 messagePacket, p2 *renderRequestPacket, fn func(*menuItem) error) error {
+	return rd.eachMenuItemWindow(dc, p1, p2, 0, 0, fn)
+}
+
+// eachMenuItemWindow is like eachMenuItem, but only renders the window of
+// limit items beginning at the offset. A limit of zero renders every item
+// following the offset.
+func (rd *RemoteDB) eachMenuItemWindow(dc *deviceConnection, p1 messagePacket, p2 *renderRequestPacket, offset, limit uint32, fn func(*menuItem) error) error {
+	txID, err := rd.sendMessage(dc, p1)
+	if err != nil {
+		return err
+	}
+
+	resp, err := rd.readResponse(dc, txID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if resp.messageType != msgTypeResponse {
-		return nil, fmt.Errorf("Invalid menu items request, got response type %#x", resp.messageType)
+		return newUnexpectedResponseError(resp.messageType)
 	}
 
-	if err := rd.sendMessage(devID, p2); err != nil {
-		return nil, err
+	if len(resp.arguments) < 2 {
+		return ErrInvalidRequest
 	}
 
-	// Add 2 for the menu header / footer
-	entryCount := int(resp.arguments[1].(fieldNumber04)) + 2
+	count, ok := resp.arguments[1].(fieldNumber04)
+	if !ok {
+		return ErrInvalidRequest
+	}
 
-	items := map[byte]*menuItem{}
+	itemCount := uint32(count)
+
+	// Requests with no results respond with an item count of 0xffffffff
+	if itemCount == menuNoResults {
+		itemCount = 0
+	}
+
+	end := itemCount
+	if limit > 0 && offset < itemCount && limit < itemCount-offset {
+		end = offset + limit
+	}
+
+	for p2.offset = offset; p2.offset < end; p2.offset += p2.limit {
+		p2.limit = end - p2.offset
+		if p2.limit > menuBatchSize {
+			p2.limit = menuBatchSize
+		}
 
-	for i := 0; i < entryCount; i++ {
-		entry, err := readMessagePacket(rd.conns[devID].conn)
+		txID, err := rd.sendMessage(dc, p2)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if entry.messageType != msgTypeMenuItem {
-			continue
+		// Add 2 for the menu header / footer
+		entryCount := int(p2.limit) + 2
+
+		// Should an item fail, the remaining items of the batch are still
+		// read so the connection is left ready for the next query.
+		var itemErr error
+
+		for i := 0; i < entryCount; i++ {
+			entry, err := rd.readResponse(dc, txID)
+			if err != nil {
+				return err
+			}
+
+			if itemErr != nil || entry.messageType != msgTypeMenuItem {
+				continue
+			}
+
+			item, err := makeMenuItem(entry)
+			if err != nil {
+				itemErr = err
+				continue
+			}
+
+			if !knownItemTypes[item.itemType] {
+				rd.warner.warn(WarningUnknownMenuItem, entry.bytes(), "Unknown menu item type %#02x (%q)", item.itemType, item.text1)
+			}
+
+			itemErr = fn(item)
 		}
 
-		item := makeMenuItem(entry)
-		items[item.itemType] = item
+		if itemErr != nil {
+			return itemErr
+		}
 	}
 
-	return menuItems(items), nil
+	return nil
 }
 
-// getArtwork requests artwork of a specific ID from the remote database.
-func (rd *RemoteDB) getArtwork(q *TrackQuery) ([]byte, error) {
+// getCachedArtwork returns the transcoded artwork from the artwork cache,
+// requesting and caching it if it has not been cached.
+func (rd *RemoteDB) getCachedArtwork(dc *deviceConnection, q *TrackQuery) ([]byte, error) {
+	key := artworkKey{
+		deviceID:  q.DeviceID,
+		slot:      q.Slot,
+		artworkID: q.artworkID,
+	}
+
+	if artwork, ok := rd.artworkCache.get(key); ok {
+		return artwork, nil
+	}
+
+	artwork, err := rd.getArtwork(dc, q)
+	if err != nil {
+		return nil, err
+	}
+
+	artwork, err = transcodeArtwork(artwork, rd.getArtworkConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	rd.artworkCache.add(key, artwork)
+
+	return artwork, nil
+}
+
+// getArtwork requests artwork of a specific ID from the remote database. When
+// high resolution artwork is configured but not available, the thumbnail
+// artwork is returned instead.
+func (rd *RemoteDB) getArtwork(dc *deviceConnection, q *TrackQuery) ([]byte, error) {
+	if rd.getArtworkConfig().HiRes {
+		artwork, err := rd.requestArtwork(dc, q, true)
+		if err != nil || len(artwork) > 0 {
+			return artwork, err
+		}
+	}
+
+	return rd.requestArtwork(dc, q, false)
+}
+
+// requestArtwork sends a single artwork request. An empty artwork is returned
+// if the device responds with something other than artwork. ErrInvalidRequest
+// is returned if the artwork argument is of the wrong type.
+func (rd *RemoteDB) requestArtwork(dc *deviceConnection, q *TrackQuery, hiRes bool) ([]byte, error) {
 	artworkRequest := &requestArtwork{
-		deviceID:  rd.deviceID,
+		deviceID:  rd.requestingDeviceID(),
 		slot:      q.Slot,
 		artworkID: q.artworkID,
+		hiRes:     hiRes,
+	}
+
+	resp, err := rd.sendRequest(dc, artworkRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.messageType != msgTypeArtwork || len(resp.arguments) < 4 {
+		return []byte{}, nil
+	}
+
+	data, ok := resp.arguments[3].(fieldBinary)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+
+	return []byte(data), nil
+}
+
+// getAnlzTag requests a tagged section of one of the analysis files of a
+// track. The returned data begins at the four character code of the tag. nil
+// is returned without an error if the player does not support the request or
+// does not have the tag for the track. ErrInvalidRequest is returned should
+// the device reject the request or respond with a malformed tag.
+func (rd *RemoteDB) getAnlzTag(q *TrackQuery, tag, fileExt string) ([]byte, error) {
+	tagRequest := &anlzTagRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+		tag:      tag,
+		fileExt:  fileExt,
+	}
+
+	resp, err := rd.executeRequest(q.DeviceID, tagRequest, msgTypeAnlzTag)
+
+	if isUnexpectedResponse(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.arguments) < 4 {
+		return nil, nil
+	}
+
+	tagData, ok := resp.arguments[3].(fieldBinary)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+
+	data := []byte(tagData)
+
+	start := bytes.Index(data, []byte(tag))
+	if start < 0 || len(data) < start+anlzTagHeaderLen {
+		return nil, nil
+	}
+
+	data = data[start:]
+
+	// The tag header specifies its own length, which must fit within the data
+	if int(be.Uint32(data[anlzTagHeaderLenOffset:])) > len(data) {
+		return nil, nil
 	}
 
-	if err := rd.sendMessage(q.DeviceID, artworkRequest); err != nil {
+	return data, nil
+}
+
+// unexpectedResponseError is returned when the response to a request is not
+// of the expected message type. This typically means the device does not
+// support the request or does not have the requested data.
+type unexpectedResponseError struct {
+	messageType uint16
+}
+
+func (e *unexpectedResponseError) Error() string {
+	return fmt.Sprintf("Invalid request, got response type %#x", e.messageType)
+}
+
+// newUnexpectedResponseError constructs the error for an unexpected response
+// type. ErrInvalidRequest is returned when the device explicitly responded
+// that the data is unavailable.
+func newUnexpectedResponseError(messageType uint16) error {
+	if messageType == msgTypeUnavailable {
+		return ErrInvalidRequest
+	}
+
+	return &unexpectedResponseError{messageType: messageType}
+}
+
+// isUnexpectedResponse reports whether the error is the result of the device
+// responding with something other than the requested data. ErrInvalidRequest
+// is not an unexpected response, it is passed through to callers.
+func isUnexpectedResponse(err error) bool {
+	_, ok := err.(*unexpectedResponseError)
+
+	return ok
+}
+
+// executeRequest sends a request message to the device, returning the single
+// response message. An error is returned if the response is not of the
+// expected message type.
+func (rd *RemoteDB) executeRequest(devID DeviceID, p messagePacket, respType uint16) (*genericPacket, error) {
+	var resp *genericPacket
+
+	err := rd.retryOnDisconnect(devID, func() (err error) {
+		devConn := rd.conn(devID)
+		if devConn == nil {
+			return ErrDeviceNotLinked
+		}
+
+		if err := devConn.acquire(); err != nil {
+			return err
+		}
+
+		defer devConn.release()
+
+		resp, err = rd.sendRequest(devConn, p)
+		return err
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
-	resp, err := readMessagePacket(rd.conns[q.DeviceID].conn)
+	if resp.messageType != respType {
+		return nil, newUnexpectedResponseError(resp.messageType)
+	}
+
+	return resp, nil
+}
+
+// sendRequest writes a message packet and reads the response message.
+func (rd *RemoteDB) sendRequest(dc *deviceConnection, p messagePacket) (*genericPacket, error) {
+	txID, err := rd.sendMessage(dc, p)
 	if err != nil {
 		return nil, err
 	}
 
-	return []byte(resp.arguments[3].(fieldBinary)), nil
+	return rd.readResponse(dc, txID)
 }
 
 // sendMessage writes a message packet to the open connection and increments
-// the transaction counter.
-func (rd *RemoteDB) sendMessage(devID DeviceID, m messagePacket) error {
-	devConn := rd.conns[devID]
+// the transaction counter. The transaction ID of the message is returned. The
+// lock of the deviceConnection must be held.
+func (rd *RemoteDB) sendMessage(dc *deviceConnection, m messagePacket) (uint32, error) {
+	conn := dc.socket()
+	if conn == nil {
+		return 0, ErrDeviceNotLinked
+	}
+
+	txID := dc.txCount
+
+	m.setTransactionID(txID)
+	if _, err := conn.Write(m.bytes()); err != nil {
+		return 0, err
+	}
+
+	dc.txCount++
+	dc.touch()
+
+	return txID, nil
+}
+
+// transactionError is returned when a response does not carry the transaction
+// ID of the request it was read for. The connection is out of sync with the
+// device and any further responses read from it cannot be trusted.
+type transactionError struct {
+	expected uint32
+	actual   uint32
+}
+
+func (e *transactionError) Error() string {
+	return fmt.Sprintf("Response transaction ID %d does not match request transaction ID %d", e.actual, e.expected)
+}
+
+// readResponse reads a single response message for the request with the
+// given transaction ID. The lock of the deviceConnection must be held.
+func (rd *RemoteDB) readResponse(dc *deviceConnection, txID uint32) (*genericPacket, error) {
+	conn := dc.socket()
+	if conn == nil {
+		return nil, ErrDeviceNotLinked
+	}
+
+	resp, err := readMessagePacket(conn)
+	if err != nil {
+		return nil, err
+	}
 
-	m.setTransactionID(devConn.txCount)
-	if _, err := devConn.conn.Write(m.bytes()); err != nil {
+	if resp.transaction != txID {
+		rd.warner.warn(WarningTransactionID, resp.bytes(), "Response from device %d has transaction ID %d, expected %d", dc.device.ID, resp.transaction, txID)
+		return nil, &transactionError{expected: txID, actual: resp.transaction}
+	}
+
+	return resp, nil
+}
+
+// isDisconnect reports whether the error returned while querying a device
+// means the connection to the device has been lost, or can no longer be used.
+func isDisconnect(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	// A desynchronized connection must be re-established
+	if _, ok := err.(*transactionError); ok {
+		return true
+	}
+
+	netErr, ok := err.(net.Error)
+
+	return ok && !netErr.Timeout()
+}
+
+// retryOnDisconnect runs the query against the device. Should the connection
+// to the device be lost, for example because the player was restarted, the
+// connection is re-established and the query is retried once. If the device
+// cannot be reconnected to immediately, reconnection continues in the
+// background as it does when the device is first linked.
+func (rd *RemoteDB) retryOnDisconnect(devID DeviceID, query func() error) error {
+	err := query()
+	if !isDisconnect(err) {
 		return err
 	}
 
-	devConn.txCount++
+	devConn := rd.conn(devID)
+	if devConn == nil {
+		return err
+	}
 
-	return nil
+	if connErr := devConn.reconnect(); connErr != nil {
+		rd.refreshConnection(devConn)
+		return err
+	}
+
+	err = query()
+	if isDisconnect(err) {
+		rd.refreshConnection(devConn)
+	}
+
+	return err
 }
 
 // openConnection initializes a new deviceConnection for the specified device.
@@ -409,12 +1425,19 @@ func (rd *RemoteDB) openConnection(dev *Device) {
 		return
 	}
 
+	policy := rd.linkPolicy(dev.Type)
+
 	conn := &deviceConnection{
-		remoteDB:   rd,
-		device:     dev,
-		lock:       &sync.Mutex{},
-		txCount:    1,
-		retryEvery: 5 * time.Second,
+		remoteDB: rd,
+		device:   dev,
+		lock:     &sync.Mutex{},
+		connLock: &sync.Mutex{},
+		txCount:  1,
+		policy:   policy,
+	}
+
+	if policy.MaxQueuedRequests > 0 {
+		conn.queue = make(chan bool, policy.MaxQueuedRequests)
 	}
 
 	conn.Open()
@@ -427,30 +1450,101 @@ func (rd *RemoteDB) openConnection(dev *Device) {
 
 // closeConnection closes the active connection for the specified device.
 func (rd *RemoteDB) closeConnection(dev *Device) {
-	if _, ok := rd.conns[dev.ID]; !ok {
+	rd.connsLock.Lock()
+	devConn, ok := rd.conns[dev.ID]
+	delete(rd.conns, dev.ID)
+	rd.connsLock.Unlock()
+
+	if !ok {
 		return
 	}
 
-	rd.conns[dev.ID].Close()
+	devConn.Close()
+	rd.removeCached(func(devID DeviceID, _ TrackSlot) bool { return devID == dev.ID })
+}
 
+// updateConnection reconnects to a device whose address or ID has changed,
+// closing the connection made using the previous attributes of the device.
+func (rd *RemoteDB) updateConnection(dev *Device) {
+	rd.connsLock.Lock()
+	previous := []*Device{}
+
+	for devID, conn := range rd.conns {
+		if devID == dev.ID || bytes.Equal(conn.device.MacAddr, dev.MacAddr) {
+			previous = append(previous, conn.device)
+		}
+	}
+
+	rd.connsLock.Unlock()
+
+	for _, prev := range previous {
+		rd.closeConnection(prev)
+	}
+
+	rd.openConnection(dev)
+}
+
+// staleConnections returns the devices with connections that are no longer on
+// the network.
+func (rd *RemoteDB) staleConnections(dm *DeviceManager) []*Device {
 	rd.connsLock.Lock()
 	defer rd.connsLock.Unlock()
 
-	delete(rd.conns, dev.ID)
+	active := dm.ActiveDeviceMap()
+	stale := []*Device{}
+
+	for devID, conn := range rd.conns {
+		if _, ok := active[devID]; !ok {
+			stale = append(stale, conn.device)
+		}
+	}
+
+	return stale
 }
 
-// refreshConnection attempts to reconnect to the specified device.
-func (rd *RemoteDB) refreshConnection(dev *Device) {
-	rd.closeConnection(dev)
+// refreshConnection closes the connection and opens a new connection to the
+// device. Nothing is done should the connection have already been replaced by
+// a newer connection to the device.
+func (rd *RemoteDB) refreshConnection(devConn *deviceConnection) {
+	dev := devConn.device
+
+	rd.connsLock.Lock()
+	current := rd.conns[dev.ID]
+
+	if current == devConn {
+		delete(rd.conns, dev.ID)
+	}
+
+	rd.connsLock.Unlock()
+
+	if current != devConn {
+		return
+	}
+
+	devConn.Close()
+	rd.removeCached(func(devID DeviceID, _ TrackSlot) bool { return devID == dev.ID })
+
 	rd.openConnection(dev)
 }
 
 // setRequestingDeviceID specifies what device ID the requests to the remote DB
 // servers should identify themselves as.
 func (rd *RemoteDB) setRequestingDeviceID(deviceID DeviceID) {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
 	rd.deviceID = deviceID
 }
 
+// requestingDeviceID returns the device ID the requests to the remote DB
+// servers identify themselves as.
+func (rd *RemoteDB) requestingDeviceID() DeviceID {
+	rd.connsLock.Lock()
+	defer rd.connsLock.Unlock()
+
+	return rd.deviceID
+}
+
 // activate begins actively listening for devices on the network hat support
 // remote database queries to be added to the PRO DJ LINK network. This
 // maintains adding and removing of device connections.
@@ -460,24 +1554,45 @@ func (rd *RemoteDB) activate(dm *DeviceManager) {
 		rd.openConnection(dev)
 	}
 
-	dm.OnDeviceAdded(DeviceListenerFunc(rd.openConnection))
-	dm.OnDeviceRemoved(DeviceListenerFunc(rd.closeConnection))
+	rd.subscriptions = []*DeviceSubscription{
+		dm.OnDeviceAdded(DeviceListenerFunc(rd.openConnection)),
+		dm.OnDeviceRemoved(DeviceListenerFunc(rd.closeConnection)),
+		dm.OnDeviceUpdated(DeviceListenerFunc(rd.updateConnection)),
+	}
 }
 
 // deactivate closes any open remote DB connections and stops waiting to
 // connect to new devices that appear on the network.
 func (rd *RemoteDB) deactivate(dm *DeviceManager) {
-	dm.RemoveListener(DeviceListenerFunc(rd.openConnection))
-	dm.RemoveListener(DeviceListenerFunc(rd.closeConnection))
+	for _, sub := range rd.subscriptions {
+		sub.Unsubscribe()
+	}
+
+	rd.subscriptions = nil
+
+	rd.connsLock.Lock()
+	devices := make([]*Device, 0, len(rd.conns))
 
 	for _, conn := range rd.conns {
-		rd.closeConnection(conn.device)
+		devices = append(devices, conn.device)
+	}
+
+	rd.connsLock.Unlock()
+
+	for _, dev := range devices {
+		rd.closeConnection(dev)
 	}
 }
 
-func newRemoteDB() *RemoteDB {
+func newRemoteDB(warner *protocolWarner) *RemoteDB {
 	return &RemoteDB{
 		conns:     map[DeviceID]*deviceConnection{},
 		connsLock: &sync.Mutex{},
+		warner:    warner,
+
+		artworkCache: newArtworkCache(defaultArtworkCacheSize),
+		trackCache:   newTrackCache(),
+		pathIndex:    newPathIndex(),
+		linkPolicies: map[DeviceType]LinkPolicy{},
 	}
 }
@@ -0,0 +1,177 @@
+// Package sessionlog provides functionality for recording the state of every
+// deck over the course of a set, so that it may be reconstructed afterwards
+// by video editors or visualization renderers.
+package sessionlog
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+)
+
+// DeckState is the state of a single deck at the time a Frame was recorded.
+type DeckState struct {
+	PlayerID    prolink.DeviceID `json:"player_id"`
+	TrackID     uint32           `json:"track_id"`
+	TrackDevice prolink.DeviceID `json:"track_device"`
+	TrackSlot   string           `json:"track_slot"`
+	PlayState   string           `json:"play_state"`
+	Elapsed     float64          `json:"elapsed"`
+	BPM         float32          `json:"bpm"`
+	Pitch       float32          `json:"pitch"`
+	IsOnAir     bool             `json:"on_air"`
+	IsMaster    bool             `json:"master"`
+}
+
+// Frame is a single line of the session log, containing the state of each
+// deck at the time it was recorded.
+type Frame struct {
+	Time  time.Time    `json:"time"`
+	Decks []*DeckState `json:"decks"`
+}
+
+// Recorder writes a Frame as a line of JSON on every interval.
+type Recorder struct {
+	monitor  *prolink.CDJStatusMonitor
+	encoder  *json.Encoder
+	interval time.Duration
+
+	lock       sync.Mutex
+	lastStatus map[prolink.DeviceID]*prolink.CDJStatus
+
+	// runLock guards starting and stopping the recorder. It is separate from
+	// lock, which the recording goroutine takes while Stop waits for it.
+	runLock      sync.Mutex
+	subscription *prolink.StatusSubscription
+
+	stop chan bool
+	done chan error
+}
+
+// statusBufferSize is the number of statuses buffered for the recorder. The
+// recorder only keeps the last status of each deck, so dropping the oldest
+// statuses when the buffer is full loses nothing of note.
+const statusBufferSize = 32
+
+// NewRecorder constructs a Recorder which will write frames to the writer
+// once per interval. The recorder will begin receiving statuses from the
+// monitor and recording once Start is called.
+func NewRecorder(monitor *prolink.CDJStatusMonitor, w io.Writer, interval time.Duration) *Recorder {
+	recorder := &Recorder{
+		monitor:    monitor,
+		encoder:    json.NewEncoder(w),
+		interval:   interval,
+		lastStatus: map[prolink.DeviceID]*prolink.CDJStatus{},
+	}
+
+	return recorder
+}
+
+// OnStatusUpdate implements the prolink.StatusHandler interface. Statuses are
+// received from the monitor while recording, so this need only be called to
+// record statuses from elsewhere.
+func (r *Recorder) OnStatusUpdate(s *prolink.CDJStatus) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastStatus[s.PlayerID] = s
+}
+
+// Start begins receiving statuses and writing frames. Starting a recorder
+// which is already recording has no effect.
+func (r *Recorder) Start() {
+	r.runLock.Lock()
+	defer r.runLock.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+
+	statuses, subscription := r.monitor.Statuses(statusBufferSize)
+
+	r.subscription = subscription
+	r.stop = make(chan bool)
+	r.done = make(chan error, 1)
+
+	go r.record(statuses, r.stop, r.done)
+}
+
+// Stop stops receiving statuses and writing frames. Any error encountered
+// while writing frames is returned. Stopping a recorder which is not
+// recording has no effect.
+func (r *Recorder) Stop() error {
+	r.runLock.Lock()
+	defer r.runLock.Unlock()
+
+	if r.stop == nil {
+		return nil
+	}
+
+	r.subscription.Unsubscribe()
+
+	close(r.stop)
+	err := <-r.done
+
+	r.stop = nil
+
+	return err
+}
+
+// record receives statuses and writes frames until stop is closed, sending
+// any error encountered while writing frames on done.
+func (r *Recorder) record(statuses <-chan *prolink.CDJStatus, stop chan bool, done chan error) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			done <- nil
+			return
+		case s := <-statuses:
+			r.OnStatusUpdate(s)
+		case now := <-ticker.C:
+			if err := r.encoder.Encode(r.frame(now)); err != nil {
+				<-stop
+				done <- err
+				return
+			}
+		}
+	}
+}
+
+// frame constructs a Frame from the last reported status of each deck.
+func (r *Recorder) frame(now time.Time) *Frame {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	frame := &Frame{
+		Time:  now,
+		Decks: make([]*DeckState, 0, len(r.lastStatus)),
+	}
+
+	for pid, s := range r.lastStatus {
+		frame.Decks = append(frame.Decks, &DeckState{
+			PlayerID:    pid,
+			TrackID:     s.TrackID,
+			TrackDevice: s.TrackDevice,
+			TrackSlot:   s.TrackSlot.String(),
+			PlayState:   s.PlayState.String(),
+			Elapsed:     r.monitor.GetElapsed(pid).Seconds(),
+			BPM:         s.TrackBPM,
+			Pitch:       s.EffectivePitch,
+			IsOnAir:     s.IsOnAir,
+			IsMaster:    s.IsMaster,
+		})
+	}
+
+	sort.Slice(frame.Decks, func(i, j int) bool {
+		return frame.Decks[i].PlayerID < frame.Decks[j].PlayerID
+	})
+
+	return frame
+}
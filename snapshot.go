@@ -0,0 +1,66 @@
+package prolink
+
+import (
+	"sort"
+	"time"
+)
+
+// DeckSnapshot is the state of a single player at the time a Snapshot was
+// taken.
+type DeckSnapshot struct {
+	Status  *CDJStatus
+	Elapsed time.Duration
+
+	// Track is the metadata of the loaded track. The track metadata is only
+	// available if it has previously been queried using RemoteDB.GetTrack,
+	// otherwise Track will be nil.
+	Track *Track
+}
+
+// Snapshot is the complete current state of the PRO DJ LINK network.
+type Snapshot struct {
+	Time    time.Time
+	Devices []*Device
+	Decks   []*DeckSnapshot
+
+	// Master is the ID of the player that is currently the tempo master. If
+	// no player is the master Master will be 0.
+	Master DeviceID
+}
+
+// Snapshot returns the current state of the network. Taking a snapshot does
+// not query any devices, so it is suitable for calling frequently.
+func (n *Network) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Time:    time.Now(),
+		Devices: n.devManager.ActiveDevices(),
+		Decks:   []*DeckSnapshot{},
+	}
+
+	sort.Slice(snapshot.Devices, func(i, j int) bool {
+		return snapshot.Devices[i].ID < snapshot.Devices[j].ID
+	})
+
+	for _, status := range n.cdjMonitor.statuses() {
+		deck := &DeckSnapshot{
+			Status:  status,
+			Elapsed: n.cdjMonitor.GetElapsed(status.PlayerID),
+		}
+
+		if q := status.TrackQuery(); q != nil {
+			deck.Track = n.remoteDB.cachedTrack(q)
+		}
+
+		if status.IsMaster {
+			snapshot.Master = status.PlayerID
+		}
+
+		snapshot.Decks = append(snapshot.Decks, deck)
+	}
+
+	sort.Slice(snapshot.Decks, func(i, j int) bool {
+		return snapshot.Decks[i].Status.PlayerID < snapshot.Decks[j].Status.PlayerID
+	})
+
+	return snapshot
+}
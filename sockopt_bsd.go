@@ -0,0 +1,31 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package prolink
+
+import (
+	"syscall"
+)
+
+// reuseAddrControl marks the socket as reusable, so that the PRO DJ LINK ports
+// may be bound by other applications on the same host that also allow reuse.
+// BSD systems require SO_REUSEPORT for multiple sockets to receive broadcast
+// packets on the same port.
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		if sockErr != nil {
+			return
+		}
+
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
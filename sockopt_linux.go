@@ -0,0 +1,23 @@
+package prolink
+
+import (
+	"syscall"
+)
+
+// reuseAddrControl marks the socket as reusable, so that the PRO DJ LINK ports
+// may be bound by other applications on the same host that also allow reuse.
+// On Linux SO_REUSEADDR allows multiple UDP sockets to bind the same port,
+// each receiving the broadcast packets sent to it.
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!linux,!windows
+
+package prolink
+
+import (
+	"syscall"
+)
+
+// reuseAddrControl does not mark the socket as reusable on platforms where it
+// is not known how to do so.
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -3,9 +3,11 @@ package prolink
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"math"
+	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
 // Status flag bitmasks
@@ -83,6 +85,8 @@ type CDJStatus struct {
 	TrackDevice    DeviceID
 	TrackSlot      TrackSlot
 	PlayState      PlayState
+	IsUSBLoaded    bool
+	IsSDLoaded     bool
 	IsOnAir        bool
 	IsSync         bool
 	IsMaster       bool
@@ -93,6 +97,9 @@ type CDJStatus struct {
 	BeatsUntilCue  uint16
 	Beat           uint32
 	PacketNum      uint32
+
+	// Firmware is the firmware version of the player, such as "1.43".
+	Firmware string
 }
 
 // TrackQuery constructs a track query object from the CDJStatus. If no track
@@ -135,21 +142,59 @@ func (s *CDJStatus) String() string {
 	)
 }
 
+// mediaStateLoaded is the state of the USB and SD slots reported in the CDJ
+// status when media is mounted in the slot.
+const mediaStateLoaded byte = 0x00
+
+// Packet types received on the status port
+const (
+	statusPacketMediaQuery    byte = 0x05
+	statusPacketMediaResponse byte = 0x06
+	statusPacketCDJ           byte = 0x0a
+	statusPacketMixer         byte = 0x29
+)
+
+// maxStatusPacketLen is the size of the largest status packet that will be
+// read. CDJ-3000 status packets are considerably longer than those of earlier
+// players.
+const maxStatusPacketLen = 0x800
+
+// statusPacketTypes are the packet types that are known to be sent to the
+// status port.
+var statusPacketTypes = map[byte]bool{
+	statusPacketMediaQuery:    true,
+	statusPacketMediaResponse: true,
+	statusPacketCDJ:           true,
+	statusPacketMixer:         true,
+}
+
+// packetToStatus constructs a CDJStatus from a status packet. If the packet is
+// a known packet type that is not a CDJ status packet nil will be returned.
 func packetToStatus(p []byte) (*CDJStatus, error) {
-	if !bytes.HasPrefix(p, prolinkHeader) {
+	if !bytes.HasPrefix(p, prolinkHeader) || len(p) <= 0x0A {
 		return nil, fmt.Errorf("CDJ status packet does not start with the expected header")
 	}
 
-	if len(p) < 0xFF {
+	if !statusPacketTypes[p[0x0A]] {
+		return nil, newPacketError(WarningUnknownPacketType, "Unknown status packet type %#02x", p[0x0A])
+	}
+
+	if p[0x0A] != statusPacketCDJ {
 		return nil, nil
 	}
 
+	if len(p) < 0xFF {
+		return nil, newPacketError(WarningUnexpectedLength, "CDJ status packet is %d bytes, expected at least %d", len(p), 0xFF)
+	}
+
 	status := &CDJStatus{
 		PlayerID:       DeviceID(p[0x21]),
 		TrackID:        be.Uint32(p[0x2C : 0x2C+4]),
 		TrackDevice:    DeviceID(p[0x28]),
 		TrackSlot:      TrackSlot(p[0x29]),
 		PlayState:      PlayState(p[0x7B]),
+		IsUSBLoaded:    p[0x6F] == mediaStateLoaded,
+		IsSDLoaded:     p[0x73] == mediaStateLoaded,
 		IsOnAir:        p[0x89]&statusFlagOnAir != 0,
 		IsSync:         p[0x89]&statusFlagSync != 0,
 		IsMaster:       p[0x89]&statusFlagMaster != 0,
@@ -160,6 +205,7 @@ func packetToStatus(p []byte) (*CDJStatus, error) {
 		BeatsUntilCue:  be.Uint16(p[0xA4 : 0xA4+2]),
 		Beat:           be.Uint32(p[0xA0 : 0xA0+4]),
 		PacketNum:      be.Uint32(p[0xC8 : 0xC8+4]),
+		Firmware:       string(bytes.TrimRight(p[0x7C:0x7C+4], "\x00")),
 	}
 
 	return status, nil
@@ -189,6 +235,64 @@ func calcBPM(p []byte) float32 {
 	return float32(val) / 100
 }
 
+// beatUnknown is reported as the beat number when the player does not know
+// the position of the beat, such as when the track has not been analyzed.
+const beatUnknown = 0xffffffff
+
+// maxBeatAdvance is the most beats the beat number may advance by between
+// status updates before the player is assumed to have jumped to another
+// position of the track.
+const maxBeatAdvance = 4
+
+// playhead tracks the elapsed play time of the track loaded on a player.
+type playhead struct {
+	trackID    uint32
+	elapsed    time.Duration
+	lastUpdate time.Time
+	pitch      float32
+	playing    bool
+	beat       uint32
+}
+
+// seeked reports whether the status shows the player moving within the track
+// other than by playing, such as cuing, searching, or jumping to a hot cue.
+func (p *playhead) seeked(s *CDJStatus) bool {
+	switch s.PlayState {
+	case PlayStateCued, PlayStateCuing, PlayStateSearching:
+		return true
+	}
+
+	if s.Beat == beatUnknown || p.beat == beatUnknown {
+		return false
+	}
+
+	return s.Beat < p.beat || s.Beat > p.beat+maxBeatAdvance
+}
+
+// beatPosition estimates the position of the current beat of the status
+// within the track, assuming the track has a constant tempo.
+func beatPosition(s *CDJStatus) time.Duration {
+	if s.Beat == beatUnknown || s.Beat == 0 || s.TrackBPM <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(s.Beat-1) * float64(time.Minute) / float64(s.TrackBPM))
+}
+
+// elapsedAt computes the elapsed time of the track at the given time. The
+// time since the last status update is scaled by the effective pitch reported
+// in that update.
+func (p *playhead) elapsedAt(now time.Time) time.Duration {
+	if !p.playing {
+		return p.elapsed
+	}
+
+	wallTime := float64(now.Sub(p.lastUpdate))
+	tempo := 1 + float64(p.pitch)/100
+
+	return p.elapsed + time.Duration(wallTime*tempo)
+}
+
 // A StatusHandler responds to status updates on a CDJ.
 type StatusHandler interface {
 	OnStatusUpdate(*CDJStatus)
@@ -205,46 +309,206 @@ func (f StatusHandlerFunc) OnStatusUpdate(s *CDJStatus) { f(s) }
 // CDJ devices on the PRO DJ LINK network.
 type CDJStatusMonitor struct {
 	handlers []StatusHandler
+	hlock    sync.Mutex
+	warner   *protocolWarner
+	activity *listenerActivity
+	filter   *sourceFilter
+
+	mediaQueries *mediaQueries
+
+	lock       sync.Mutex
+	playheads  map[DeviceID]*playhead
+	lastStatus map[DeviceID]*CDJStatus
 }
 
 // OnStatusUpdate registers a StatusHandler to be called when any CDJ on the
 // PRO DJ LINK network reports its status.
 func (sm *CDJStatusMonitor) OnStatusUpdate(h StatusHandler) {
+	sm.hlock.Lock()
+	defer sm.hlock.Unlock()
+
 	sm.handlers = append(sm.handlers, h)
 }
 
+// removeChannel removes the handler backing a Statuses channel. Handlers are
+// matched by type first, as a StatusHandlerFunc cannot be compared.
+func (sm *CDJStatusMonitor) removeChannel(c *statusChannel) {
+	sm.hlock.Lock()
+	defer sm.hlock.Unlock()
+
+	kept := make([]StatusHandler, 0, len(sm.handlers))
+
+	for _, handler := range sm.handlers {
+		if ch, ok := handler.(*statusChannel); !ok || ch != c {
+			kept = append(kept, handler)
+		}
+	}
+
+	sm.handlers = kept
+}
+
+// GetElapsed reports how long the track loaded on the given player has been
+// playing for. The elapsed time is integrated from each status update using
+// the effective pitch at that time, so pitch changes during playback are
+// accounted for.
+//
+// The elapsed time is reset when a new track is loaded. When the player cues,
+// searches, or jumps to another beat, such as for a hot cue or loop, the
+// elapsed time is re-based on the beat number reported by the player. The
+// re-based time assumes the track has a constant tempo, so is approximate.
+func (sm *CDJStatusMonitor) GetElapsed(pid DeviceID) time.Duration {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	ph, ok := sm.playheads[pid]
+	if !ok {
+		return 0
+	}
+
+	return ph.elapsedAt(time.Now())
+}
+
+// LastStatus returns the most recent status reported by the given player. nil
+// is returned if the player has not reported its status.
+func (sm *CDJStatusMonitor) LastStatus(pid DeviceID) *CDJStatus {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	return sm.lastStatus[pid]
+}
+
+// statuses returns the most recent status reported by each player.
+func (sm *CDJStatusMonitor) statuses() []*CDJStatus {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	statuses := make([]*CDJStatus, 0, len(sm.lastStatus))
+
+	for _, s := range sm.lastStatus {
+		statuses = append(statuses, s)
+	}
+
+	return statuses
+}
+
+// removePlayer forgets the last status and playhead of a player which has
+// been removed from the network.
+func (sm *CDJStatusMonitor) removePlayer(dev *Device) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	delete(sm.lastStatus, dev.ID)
+	delete(sm.playheads, dev.ID)
+}
+
+// recordStatus stores the reported status and advances the playhead of the
+// player reporting its status.
+func (sm *CDJStatusMonitor) recordStatus(s *CDJStatus) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	sm.lastStatus[s.PlayerID] = s
+
+	now := time.Now()
+
+	ph, ok := sm.playheads[s.PlayerID]
+
+	switch {
+	case !ok || ph.trackID != s.TrackID:
+		ph = &playhead{trackID: s.TrackID}
+		sm.playheads[s.PlayerID] = ph
+	case ph.seeked(s):
+		ph.elapsed = beatPosition(s)
+	default:
+		ph.elapsed = ph.elapsedAt(now)
+	}
+
+	ph.beat = s.Beat
+	ph.lastUpdate = now
+	ph.pitch = s.EffectivePitch
+	ph.playing = s.PlayState == PlayStatePlaying || s.PlayState == PlayStateLooping
+}
+
+// handleMediaResponse delivers media response packets to any media queries
+// waiting for the response.
+func (sm *CDJStatusMonitor) handleMediaResponse(packet []byte) {
+	if packet[0x0A] != statusPacketMediaResponse {
+		return
+	}
+
+	info, err := mediaSlotInfoFromPacket(packet)
+	if err != nil {
+		sm.warner.warnPacket(err, packet)
+		return
+	}
+
+	sm.mediaQueries.respond(info)
+}
+
 // activate triggers the CDJStatusMonitor to begin listening for status packets
 // given a UDP connection to listen on.
-func (sm *CDJStatusMonitor) activate(listenConn io.Reader) {
-	packet := make([]byte, 512)
+func (sm *CDJStatusMonitor) activate(listenConn *net.UDPConn) {
+	packet := make([]byte, maxStatusPacketLen)
 
-	statusUpdateHandler := func() {
-		n, err := listenConn.Read(packet)
-		if err != nil || n == 0 {
-			return
+	statusUpdateHandler := func() error {
+		n, addr, err := listenConn.ReadFromUDP(packet)
+		if err != nil {
+			return err
 		}
 
+		if n == 0 || !sm.filter.accepts(addr) {
+			return nil
+		}
+
+		sm.activity.received()
+
 		status, err := packetToStatus(packet[:n])
 		if err != nil {
-			return
+			sm.warner.warnPacket(err, packet[:n])
+			return nil
 		}
 
 		if status == nil {
-			return
+			sm.handleMediaResponse(packet[:n])
+			return nil
 		}
 
+		sm.recordStatus(status)
+
+		sm.hlock.Lock()
+		// Status channels never block and are sent to in order
 		for _, h := range sm.handlers {
-			go h.OnStatusUpdate(status)
+			if c, ok := h.(*statusChannel); ok {
+				c.OnStatusUpdate(status)
+			} else {
+				go h.OnStatusUpdate(status)
+			}
 		}
+		sm.hlock.Unlock()
+
+		return nil
 	}
 
+	generation := sm.activity.start()
+
+	// Listen for status packets until the connection is closed
 	go func() {
-		for {
-			statusUpdateHandler()
+		defer sm.activity.stop(generation)
+
+		for statusUpdateHandler() == nil {
 		}
 	}()
 }
 
-func newCDJStatusMonitor() *CDJStatusMonitor {
-	return &CDJStatusMonitor{handlers: []StatusHandler{}}
+func newCDJStatusMonitor(warner *protocolWarner, filter *sourceFilter) *CDJStatusMonitor {
+	return &CDJStatusMonitor{
+		handlers:   []StatusHandler{},
+		playheads:  map[DeviceID]*playhead{},
+		lastStatus: map[DeviceID]*CDJStatus{},
+		warner:     warner,
+		activity:   &listenerActivity{},
+		filter:     filter,
+
+		mediaQueries: newMediaQueries(),
+	}
 }
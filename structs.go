@@ -19,11 +19,38 @@ import (
 // identifiers are used for requests, others represent response messages.
 const (
 	// request messages
-	msgTypeIntroduce     uint16 = 0x0000
-	msgTypeGetMetadata   uint16 = 0x2002
-	msgTypeGetArtwork    uint16 = 0x2003
-	msgTypeGetTrackInfo  uint16 = 0x2102
-	msgTypeGetCDMetadata uint16 = 0x2202
+	msgTypeIntroduce             uint16 = 0x0000
+	msgTypeGetMetadata           uint16 = 0x2002
+	msgTypeGetArtwork            uint16 = 0x2003
+	msgTypeGetTrackInfo          uint16 = 0x2102
+	msgTypeGetUnanalyzedMetadata uint16 = 0x2202
+	msgTypeGetCueListExt         uint16 = 0x2b04
+	msgTypeGetBeatGrid           uint16 = 0x2204
+	msgTypeGetWavePrev           uint16 = 0x2004
+	msgTypeGetWaveDetail         uint16 = 0x2904
+	msgTypeGetAnlzTag            uint16 = 0x2c04
+
+	// browse menu requests
+	msgTypeMenuGenre                     uint16 = 0x1001
+	msgTypeMenuTrack                     uint16 = 0x1004
+	msgTypeMenuArtistsForGenre           uint16 = 0x1101
+	msgTypeMenuAlbumsForGenreArtist      uint16 = 0x1201
+	msgTypeMenuTracksForGenreArtistAlbum uint16 = 0x1301
+	msgTypeMenuBPM                       uint16 = 0x1006
+	msgTypeMenuTracksForBPMDistance      uint16 = 0x1206
+	msgTypeMenuRating                    uint16 = 0x1007
+	msgTypeMenuTracksForRating           uint16 = 0x1107
+	msgTypeMenuLabel                     uint16 = 0x100a
+	msgTypeMenuArtistsForLabel           uint16 = 0x110a
+	msgTypeMenuAlbumsForLabelArtist      uint16 = 0x120a
+	msgTypeMenuTracksForLabelArtistAlbum uint16 = 0x130a
+	msgTypeMenuOriginalArtist            uint16 = 0x100b
+	msgTypeMenuAlbumsForOriginalArtist   uint16 = 0x110b
+	msgTypeMenuTracksForOriginalAlbum    uint16 = 0x120b
+	msgTypeMenuHistory                   uint16 = 0x1012
+	msgTypeMenuTracksForHistory          uint16 = 0x1112
+	msgTypeMenuKey                       uint16 = 0x100c
+	msgTypeMenuTracksForKeyDistance      uint16 = 0x120c
 
 	// render menu requests
 	msgTypeRenderRequest uint16 = 0x3000
@@ -34,6 +61,14 @@ const (
 	msgTypeMenuItem   uint16 = 0x4101
 	msgTypeMenuHeader uint16 = 0x4001
 	msgTypeMenuFooter uint16 = 0x4201
+	msgTypeCueListExt uint16 = 0x4e02
+	msgTypeBeatGrid   uint16 = 0x4602
+	msgTypeWavePrev   uint16 = 0x4402
+	msgTypeWaveDetail uint16 = 0x4a02
+	msgTypeAnlzTag    uint16 = 0x4f02
+
+	// response when the requested data is unavailable
+	msgTypeUnavailable uint16 = 0x4003
 )
 
 // Render targets aren't fully understood, but they seem to relate a bit to
@@ -49,19 +84,27 @@ const (
 // When receiving a msgTypeMenuItem a item type field is included, this list
 // contains the various item types.
 const (
-	itemTypePath      = 0x00
-	itemTypeAlbum     = 0x02
-	itemTypeDisc      = 0x03
-	itemTypeTitle     = 0x04
-	itemTypeGenre     = 0x06
-	itemTypeArtist    = 0x07
-	itemTypeRating    = 0x0a
-	itemTypeDuration  = 0x0b
-	itemTypeLabel     = 0x0e
-	itemTypeKey       = 0x0f
-	itemTypeColor     = 0x13
-	itemTypeComment   = 0x23
-	itemTypeDateAdded = 0x2e
+	itemTypePath           = 0x00
+	itemTypeFolder         = 0x01
+	itemTypeAlbum          = 0x02
+	itemTypeDisc           = 0x03
+	itemTypeTitle          = 0x04
+	itemTypeGenre          = 0x06
+	itemTypeArtist         = 0x07
+	itemTypePlaylist       = 0x08
+	itemTypeRating         = 0x0a
+	itemTypeDuration       = 0x0b
+	itemTypeTempo          = 0x0d
+	itemTypeLabel          = 0x0e
+	itemTypeKey            = 0x0f
+	itemTypeBitRate        = 0x10
+	itemTypeYear           = 0x11
+	itemTypeColor          = 0x13
+	itemTypeComment        = 0x23
+	itemTypeHistory        = 0x24
+	itemTypeOriginalArtist = 0x28
+	itemTypeRemixer        = 0x29
+	itemTypeDateAdded      = 0x2e
 
 	// item colors
 	itemTypeColorNone   = 0x13
@@ -75,6 +118,40 @@ const (
 	itemTypeColorPurple = 0x1b
 )
 
+// knownItemTypes is the set of menu item types that are understood. Menu items
+// of other types are reported as protocol warnings.
+var knownItemTypes = map[byte]bool{
+	itemTypePath:           true,
+	itemTypeFolder:         true,
+	itemTypeAlbum:          true,
+	itemTypeDisc:           true,
+	itemTypeTitle:          true,
+	itemTypeGenre:          true,
+	itemTypeArtist:         true,
+	itemTypePlaylist:       true,
+	itemTypeRating:         true,
+	itemTypeDuration:       true,
+	itemTypeTempo:          true,
+	itemTypeLabel:          true,
+	itemTypeKey:            true,
+	itemTypeBitRate:        true,
+	itemTypeYear:           true,
+	itemTypeColorNone:      true,
+	itemTypeColorPink:      true,
+	itemTypeColorRed:       true,
+	itemTypeColorOrange:    true,
+	itemTypeColorYellow:    true,
+	itemTypeColorGreen:     true,
+	itemTypeColorAqua:      true,
+	itemTypeColorBlue:      true,
+	itemTypeColorPurple:    true,
+	itemTypeComment:        true,
+	itemTypeHistory:        true,
+	itemTypeOriginalArtist: true,
+	itemTypeRemixer:        true,
+	itemTypeDateAdded:      true,
+}
+
 // date layout for the date added field
 const dateAddedLayout = "2006-01-02"
 
@@ -283,20 +360,22 @@ func (p *introducePacket) String() string {
 }
 
 // metadataRequestPacket is the message that must be sent to request for track
-// metadata.
+// metadata. Tracks that have not been analyzed by rekordbox, such as tracks on
+// CDs, must be requested as unanalyzed.
 type metadataRequestPacket struct {
 	transactionPacket
-	deviceID DeviceID
-	slot     TrackSlot
-	trackID  uint32
+	deviceID   DeviceID
+	slot       TrackSlot
+	trackID    uint32
+	unanalyzed bool
 }
 
 func (p *metadataRequestPacket) bytes() []byte {
 	messageType := msgTypeGetMetadata
 
-	// CD Metadata requests have their own message type
-	if p.slot == TrackSlotCD {
-		messageType = msgTypeGetCDMetadata
+	// Unanalyzed and CD Metadata requests have their own message type
+	if p.unanalyzed || p.slot == TrackSlotCD {
+		messageType = msgTypeGetUnanalyzedMetadata
 	}
 
 	args := []field{
@@ -348,6 +427,42 @@ func (p *trackInfoRequestPacket) String() string {
 	return hex.Dump(p.bytes())
 }
 
+// menuRequestPacket is the message that must be sent to request that a browse
+// menu (such as the list of genres) be prepared for rendering. Menus nested
+// within other menus are requested by passing the IDs of the parent items.
+type menuRequestPacket struct {
+	transactionPacket
+	messageType uint16
+	deviceID    DeviceID
+	slot        TrackSlot
+	sortOrder   SortOrder
+	parentIDs   []uint32
+}
+
+func (p *menuRequestPacket) bytes() []byte {
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderMainMenu),
+		fieldNumber04(uint32(p.sortOrder)),
+	}
+
+	for _, id := range p.parentIDs {
+		args = append(args, fieldNumber04(id))
+	}
+
+	request := &genericPacket{
+		messageType: p.messageType,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *menuRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
 // renderRequestPacket is the message that must be sent to request that data is
 // rendered back from the remote DB to the client.
 type renderRequestPacket struct {
@@ -391,12 +506,14 @@ func (p *renderRequestPacket) String() string {
 }
 
 // requestArtwork is the message that must be sent to request artwork binary
-// data.
+// data. Nexus 2 and newer players will serve a larger rendition of the
+// artwork when hiRes is requested.
 type requestArtwork struct {
 	transactionPacket
 	deviceID  DeviceID
 	slot      TrackSlot
 	artworkID uint32
+	hiRes     bool
 }
 
 func (p *requestArtwork) bytes() []byte {
@@ -405,6 +522,10 @@ func (p *requestArtwork) bytes() []byte {
 		fieldNumber04(p.artworkID),
 	}
 
+	if p.hiRes {
+		args = append(args, fieldNumber04(1))
+	}
+
 	request := &genericPacket{
 		messageType: msgTypeGetArtwork,
 		arguments:   args,
@@ -419,6 +540,185 @@ func (p *requestArtwork) String() string {
 	return hex.Dump(p.bytes())
 }
 
+// cueListRequestPacket is the message that must be sent to request the memory
+// cues, hot cues, and loops of a track. This requests the extended cue list,
+// which includes cue colors and comments.
+type cueListRequestPacket struct {
+	transactionPacket
+	deviceID DeviceID
+	slot     TrackSlot
+	trackID  uint32
+}
+
+func (p *cueListRequestPacket) bytes() []byte {
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderSystem),
+		fieldNumber04(p.trackID),
+		fieldNumber04(0), // (?) Unknown what this field is for
+	}
+
+	request := &genericPacket{
+		messageType: msgTypeGetCueListExt,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *cueListRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
+// beatGridRequestPacket is the message that must be sent to request the beat
+// grid of a track.
+type beatGridRequestPacket struct {
+	transactionPacket
+	deviceID DeviceID
+	slot     TrackSlot
+	trackID  uint32
+}
+
+func (p *beatGridRequestPacket) bytes() []byte {
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderSystem),
+		fieldNumber04(p.trackID),
+	}
+
+	request := &genericPacket{
+		messageType: msgTypeGetBeatGrid,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *beatGridRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
+// waveformPreviewRequestPacket is the message that must be sent to request the
+// waveform preview of a track.
+type waveformPreviewRequestPacket struct {
+	transactionPacket
+	deviceID DeviceID
+	slot     TrackSlot
+	trackID  uint32
+}
+
+func (p *waveformPreviewRequestPacket) bytes() []byte {
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderSystem),
+		fieldNumber04(1), // (?) Unknown what this field is for
+		fieldNumber04(p.trackID),
+		fieldNumber04(0), // (?) Unknown what this field is for
+	}
+
+	request := &genericPacket{
+		messageType: msgTypeGetWavePrev,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *waveformPreviewRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
+// waveformDetailRequestPacket is the message that must be sent to request the
+// detailed (scrolling) waveform of a track.
+type waveformDetailRequestPacket struct {
+	transactionPacket
+	deviceID DeviceID
+	slot     TrackSlot
+	trackID  uint32
+}
+
+func (p *waveformDetailRequestPacket) bytes() []byte {
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderSystem),
+		fieldNumber04(p.trackID),
+		fieldNumber04(0), // (?) Unknown what this field is for
+	}
+
+	request := &genericPacket{
+		messageType: msgTypeGetWaveDetail,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *waveformDetailRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
+// anlzTagRequestPacket is the message that must be sent to request a tagged
+// section of one of the analysis files rekordbox exports for a track. The tag
+// and file extension are four character codes, for example PWV5 and EXT.
+type anlzTagRequestPacket struct {
+	transactionPacket
+	deviceID DeviceID
+	slot     TrackSlot
+	trackID  uint32
+	tag      string
+	fileExt  string
+}
+
+func (p *anlzTagRequestPacket) bytes() []byte {
+	// The four character codes are sent as little endian numbers
+	tag := make([]byte, 4)
+	copy(tag, p.tag)
+
+	fileExt := make([]byte, 4)
+	copy(fileExt, p.fileExt)
+
+	args := []field{
+		makeRequestField(p.deviceID, p.slot, renderSystem),
+		fieldNumber04(p.trackID),
+		fieldNumber04(le.Uint32(tag)),
+		fieldNumber04(le.Uint32(fileExt)),
+	}
+
+	request := &genericPacket{
+		messageType: msgTypeGetAnlzTag,
+		arguments:   args,
+	}
+
+	request.transaction = p.transaction
+
+	return request.bytes()
+}
+
+func (p *anlzTagRequestPacket) String() string {
+	return hex.Dump(p.bytes())
+}
+
+// Analysis file tags and file extensions that may be requested using the
+// anlzTagRequestPacket.
+const (
+	anlzFileExtended = "EXT"
+
+	anlzTagColorWaveformDetail = "PWV5"
+	anlzTagSongStructure       = "PSSI"
+)
+
+// Every analysis tag begins with a common header of the four character code,
+// the length of the tags header, and the length of the entire tag. All values
+// of the analysis files are big endian.
+const (
+	anlzTagHeaderLenOffset = 0x04
+	anlzTagHeaderLen       = 0x0c
+)
+
 // menuItem is a higher level convinience struct that is created from a generic
 // packet for a menu item type
 type menuItem struct {
@@ -430,21 +730,38 @@ type menuItem struct {
 }
 
 // makeMenuItem constructs a menuItem from a genericPacket, pulling out
-// arguments as their correct struct fields.
-func makeMenuItem(p *genericPacket) *menuItem {
+// arguments as their correct struct fields. ErrInvalidRequest is returned if
+// the packet is missing arguments or they are of the wrong type.
+func makeMenuItem(p *genericPacket) (*menuItem, error) {
+	if len(p.arguments) < 9 {
+		return nil, ErrInvalidRequest
+	}
+
+	num, numOk := p.arguments[1].(fieldNumber04)
+	text1, text1Ok := p.arguments[3].(fieldString)
+	text2, text2Ok := p.arguments[5].(fieldString)
+	itemType, itemTypeOk := p.arguments[6].(fieldNumber04)
+	artworkID, artworkIDOk := p.arguments[8].(fieldNumber04)
+
+	if !numOk || !text1Ok || !text2Ok || !itemTypeOk || !artworkIDOk {
+		return nil, ErrInvalidRequest
+	}
+
 	// Single byte fields (fieldNumber01) don't appear to be supported in
 	// arguments list, so even though the menu item type is a single byte we
 	// still have to extract it as byte
 	typeBytes := make([]byte, 4)
-	be.PutUint32(typeBytes, uint32(p.arguments[6].(fieldNumber04)))
+	be.PutUint32(typeBytes, uint32(itemType))
 
-	return &menuItem{
-		num:       uint32(p.arguments[1].(fieldNumber04)),
-		text1:     string(p.arguments[3].(fieldString)),
-		text2:     string(p.arguments[5].(fieldString)),
-		artworkID: uint32(p.arguments[8].(fieldNumber04)),
+	item := &menuItem{
+		num:       uint32(num),
+		text1:     string(text1),
+		text2:     string(text2),
+		artworkID: uint32(artworkID),
 		itemType:  typeBytes[3:][0],
 	}
+
+	return item, nil
 }
 
 // menuItem is a convinience struct that adds some safe getter methods for
@@ -518,16 +835,23 @@ func readMessagePacket(conn io.Reader) (*genericPacket, error) {
 		return nil, err
 	}
 
+	txID, txOk := txIDField.(fieldNumber04)
+	msgType, msgOk := msgTypeField.(fieldNumber02)
+	argsCount, argsOk := argsCountField.(fieldNumber01)
+
+	if !txOk || !msgOk || !argsOk {
+		return nil, fmt.Errorf("Invalid packet, message header fields are malformed")
+	}
+
 	// XXX: This is an absolute hack, but for whatever reason when requesting
 	// artwork it will specify that it has 4 arguments, but if there is no
 	// artwork *will only send 3*. in which case we cannot try and read the 4th
 	// argument. Pioneer WHY??
-	artworkHack := uint16(msgTypeField.(fieldNumber02)) == msgTypeArtwork
+	artworkHack := uint16(msgType) == msgTypeArtwork
 
-	argsCount := int(argsCountField.(fieldNumber01))
 	argFields := make([]field, argsCount)
 
-	for i := 0; i < argsCount; i++ {
+	for i := 0; i < int(argsCount); i++ {
 		argField, err := readField(conn)
 		if err != nil {
 			return nil, err
@@ -536,62 +860,64 @@ func readMessagePacket(conn io.Reader) (*genericPacket, error) {
 		argFields[i] = argField
 
 		// XXX: See note above. WHY PIONEER??
-		if artworkHack && i == 2 && int32(argField.(fieldNumber04)) == 0 {
+		if n, ok := argField.(fieldNumber04); artworkHack && i == 2 && ok && n == 0 {
 			argFields[3] = fieldBinary{}
 			break
 		}
 	}
 
 	packet := &genericPacket{
-		messageType: uint16(msgTypeField.(fieldNumber02)),
+		messageType: uint16(msgType),
 		arguments:   argFields,
 	}
 
-	packet.transaction = uint32(txIDField.(fieldNumber04))
+	packet.transaction = uint32(txID)
 
 	return packet, nil
 }
 
 // readField reads a single field type, returning the parsed field object that
-// implements the field interface. Supports all defined fields.
+// implements the field interface. Supports all defined fields. Exactly the
+// length of the field is read, regardless of how the field is split across
+// reads of the connection.
 func readField(conn io.Reader) (field, error) {
 	fieldType := make([]byte, 1)
-	if _, err := conn.Read(fieldType); err != nil {
+	if _, err := io.ReadFull(conn, fieldType); err != nil {
 		return nil, err
 	}
 
 	switch fieldType[0] {
 	case fieldTypeNumber01:
 		fieldByte := make([]byte, 1)
-		if _, err := conn.Read(fieldByte); err != nil {
+		if _, err := io.ReadFull(conn, fieldByte); err != nil {
 			return nil, err
 		}
 
 		return fieldNumber01(fieldByte[0]), nil
 	case fieldTypeNumber02:
 		fieldBytes := make([]byte, 2)
-		if _, err := conn.Read(fieldBytes); err != nil {
+		if _, err := io.ReadFull(conn, fieldBytes); err != nil {
 			return nil, err
 		}
 
 		return fieldNumber02(be.Uint16(fieldBytes)), nil
 	case fieldTypeNumber04:
 		fieldBytes := make([]byte, 4)
-		if _, err := conn.Read(fieldBytes); err != nil {
+		if _, err := io.ReadFull(conn, fieldBytes); err != nil {
 			return nil, err
 		}
 
 		return fieldNumber04(be.Uint32(fieldBytes)), nil
 	case fieldTypeString:
 		fieldLenBytes := make([]byte, 4)
-		if _, err := conn.Read(fieldLenBytes); err != nil {
+		if _, err := io.ReadFull(conn, fieldLenBytes); err != nil {
 			return nil, err
 		}
 
 		stringLen := be.Uint32(fieldLenBytes)
 
 		s := make([]byte, stringLen*2)
-		if _, err := conn.Read(s); err != nil {
+		if _, err := io.ReadFull(conn, s); err != nil {
 			return nil, err
 		}
 
@@ -600,18 +926,24 @@ func readField(conn io.Reader) (field, error) {
 			str16Bit = append(str16Bit, be.Uint16(s[:2]))
 		}
 
+		if stringLen == 0 {
+			return fieldString(""), nil
+		}
+
 		// Remove the trailing NULL character
 		return fieldString(utf16.Decode(str16Bit)[:stringLen-1]), nil
 	case fieldTypeBinary:
 		fieldLenBytes := make([]byte, 4)
-		if _, err := conn.Read(fieldLenBytes); err != nil {
+		if _, err := io.ReadFull(conn, fieldLenBytes); err != nil {
 			return nil, err
 		}
 
 		dataSize := be.Uint32(fieldLenBytes)
 
 		data := make([]byte, dataSize)
-		io.ReadFull(conn, data)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return nil, err
+		}
 
 		return fieldBinary(data), nil
 	}
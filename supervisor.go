@@ -0,0 +1,278 @@
+package prolink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// supervisorInterval is how often the supervisor checks the health of the
+// network.
+const supervisorInterval = 5 * time.Second
+
+// defaultSilenceTimeout is how long the network may be silent after having
+// received packets before the UDP sockets are reopened.
+const defaultSilenceTimeout = 30 * time.Second
+
+// resumeThreshold is how far the wall clock may advance between checks before
+// the host is assumed to have been asleep.
+const resumeThreshold = 3 * supervisorInterval
+
+// listenerActivity records the activity of a goroutine listening for packets.
+// Each time the listener is started it is given a new generation, so that a
+// previous listener stopping does not mark its replacement as stopped.
+type listenerActivity struct {
+	lock       sync.Mutex
+	generation int
+	running    bool
+	lastPacket time.Time
+
+	// listeners tracks every listener goroutine that has not yet stopped.
+	listeners sync.WaitGroup
+}
+
+// start marks a new listener as running, returning its generation.
+func (a *listenerActivity) start() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.generation++
+	a.running = true
+	a.listeners.Add(1)
+
+	return a.generation
+}
+
+// stop marks the listener of the given generation as stopped.
+func (a *listenerActivity) stop(generation int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.generation == generation {
+		a.running = false
+	}
+
+	a.listeners.Done()
+}
+
+// wait blocks until every listener has stopped.
+func (a *listenerActivity) wait() {
+	a.listeners.Wait()
+}
+
+// received records that a packet was received.
+func (a *listenerActivity) received() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastPacket = time.Now()
+}
+
+// status reports if the listener is running and when it last received a
+// packet.
+func (a *listenerActivity) status() (bool, time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.running, a.lastPacket
+}
+
+// supervisor periodically checks the health of the network, restarting
+// components that have failed. This allows unattended installations to
+// recover from network interfaces going down, sockets closing, and devices
+// disappearing without being removed.
+type supervisor struct {
+	cancel  chan bool
+	running bool
+
+	// timeoutLock guards the silenceTimeout, which may be configured while
+	// the supervisor is running.
+	timeoutLock    sync.Mutex
+	silenceTimeout time.Duration
+
+	lastRestart time.Time
+	lastCheck   time.Time
+
+	// silentSince is the last packet received before the listeners were
+	// restarted for being silent. The listeners are only restarted once for
+	// each silence, as a network with no devices is silent by design.
+	silentSince time.Time
+
+	// ifaceName and ifaceAddrs record the addresses of the target interface
+	// when last checked, so that address changes can be detected.
+	ifaceName  string
+	ifaceAddrs string
+}
+
+// activate starts supervising the network.
+func (s *supervisor) activate(n *Network) {
+	if s.running {
+		return
+	}
+
+	ticker := time.NewTicker(supervisorInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.cancel:
+				return
+			case <-ticker.C:
+				s.check(n)
+			}
+		}
+	}()
+
+	s.running = true
+}
+
+// deactivate stops supervising the network.
+func (s *supervisor) deactivate() {
+	if s.running {
+		s.cancel <- true
+		s.running = false
+	}
+}
+
+// check restarts the UDP listeners if either has stopped, if no packets have
+// been received for the silence timeout after previously receiving packets,
+// if the host has resumed from sleep, or if the addresses of the target
+// interface have changed. Remote database connections to devices no longer on
+// the network are closed. Each action taken is reported as a ProtocolWarning.
+func (s *supervisor) check(n *Network) {
+	// The monotonic clock does not advance while the host is asleep, so the
+	// wall clock is compared instead.
+	now := time.Now()
+	asleep := time.Duration(0)
+
+	if !s.lastCheck.IsZero() {
+		asleep = now.Round(0).Sub(s.lastCheck.Round(0)) - supervisorInterval
+	}
+
+	s.lastCheck = now
+
+	ifaceChanged := s.checkInterface(n)
+
+	announceRunning, lastAnnounce := n.devManager.activity.status()
+	statusRunning, lastStatus := n.cdjMonitor.activity.status()
+
+	// A stopped DeviceManager is not listening by choice
+	announceRunning = announceRunning || n.devManager.isStopped()
+
+	lastPacket := lastAnnounce
+	if lastStatus.After(lastPacket) {
+		lastPacket = lastStatus
+	}
+
+	silenceTimeout := s.getSilenceTimeout()
+
+	silent := !lastPacket.IsZero() &&
+		lastPacket.After(s.silentSince) &&
+		time.Since(lastPacket) > silenceTimeout &&
+		time.Since(s.lastRestart) > silenceTimeout
+
+	switch {
+	case asleep > resumeThreshold:
+		n.warner.warn(WarningResumed, nil, "Host resumed after %s, restarting listeners", asleep.Round(time.Second))
+		s.restart(n)
+	case ifaceChanged:
+		n.warner.warn(WarningNetworkChanged, nil, "Addresses of interface %s changed to %s, restarting listeners", s.ifaceName, s.ifaceAddrs)
+		s.restart(n)
+	case !announceRunning || !statusRunning:
+		n.warner.warn(WarningListenerStopped, nil, "Listener stopped (announce running: %t, status running: %t), restarting", announceRunning, statusRunning)
+		s.restart(n)
+	case silent:
+		n.warner.warn(WarningNetworkSilent, nil, "No packets received since %s, restarting listeners", lastPacket.Format(time.RFC3339))
+		s.silentSince = lastPacket
+		s.restart(n)
+	}
+
+	for _, dev := range n.remoteDB.staleConnections(n.devManager) {
+		n.warner.warn(WarningStaleConnection, nil, "Closing remote database connection to %s, which is no longer on the network", dev)
+		n.remoteDB.closeConnection(dev)
+	}
+}
+
+// checkInterface reports if the addresses of the target interface have changed
+// since the previous check, such as when a DHCP lease is renewed or the cable
+// is reconnected. The interface is looked up again by name, as it may have
+// been recreated, and the source filter is updated with the new addresses.
+// Changes to an interface with no addresses are not reported, the interface
+// being down.
+func (s *supervisor) checkInterface(n *Network) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.TargetInterface == nil {
+		return false
+	}
+
+	addrs := ""
+	iface, err := net.InterfaceByName(n.TargetInterface.Name)
+
+	if err == nil {
+		if ifaceAddrs, err := iface.Addrs(); err == nil && len(ifaceAddrs) > 0 {
+			addrs = fmt.Sprint(ifaceAddrs)
+		}
+	}
+
+	// The interface was reconfigured using SetInterface
+	if s.ifaceName != n.TargetInterface.Name {
+		s.ifaceName = n.TargetInterface.Name
+		s.ifaceAddrs = addrs
+		return false
+	}
+
+	if addrs == s.ifaceAddrs {
+		return false
+	}
+
+	s.ifaceAddrs = addrs
+
+	if addrs == "" {
+		return false
+	}
+
+	if err := n.filter.setInterface(iface); err != nil {
+		return false
+	}
+
+	n.TargetInterface = iface
+
+	return true
+}
+
+// setSilenceTimeout configures the silence timeout of the supervisor.
+func (s *supervisor) setSilenceTimeout(timeout time.Duration) {
+	s.timeoutLock.Lock()
+	defer s.timeoutLock.Unlock()
+
+	s.silenceTimeout = timeout
+}
+
+// getSilenceTimeout returns the silence timeout of the supervisor.
+func (s *supervisor) getSilenceTimeout() time.Duration {
+	s.timeoutLock.Lock()
+	defer s.timeoutLock.Unlock()
+
+	return s.silenceTimeout
+}
+
+// restart reopens the UDP sockets and restarts the listeners and announcer.
+func (s *supervisor) restart(n *Network) {
+	s.lastRestart = time.Now()
+
+	if err := n.restartListeners(); err != nil {
+		n.warner.warn(WarningListenerStopped, nil, "Failed to restart listeners: %s", err)
+	}
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{
+		cancel:         make(chan bool),
+		silenceTimeout: defaultSilenceTimeout,
+	}
+}
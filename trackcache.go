@@ -0,0 +1,83 @@
+package prolink
+
+import (
+	"sync"
+)
+
+// trackKey identifies a track. Track IDs are only unique to the media slot
+// the track was read from. Tracks queried without their path and artwork are
+// cached separately from complete tracks.
+type trackKey struct {
+	deviceID     DeviceID
+	slot         TrackSlot
+	trackID      uint32
+	metadataOnly bool
+}
+
+// mediaKey identifies a media slot of a player.
+type mediaKey struct {
+	deviceID DeviceID
+	slot     TrackSlot
+}
+
+// trackCache caches the metadata of tracks. Tracks are removed from the cache
+// once the media slot they were read from is unmounted.
+type trackCache struct {
+	lock    sync.Mutex
+	tracks  map[trackKey]*Track
+	mounted map[mediaKey]bool
+}
+
+// get returns a copy of the cached track.
+func (c *trackCache) get(key trackKey) (*Track, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	track, ok := c.tracks[key]
+	if !ok {
+		return nil, false
+	}
+
+	trackCopy := *track
+
+	return &trackCopy, true
+}
+
+func (c *trackCache) add(key trackKey, track *Track) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	trackCopy := *track
+	c.tracks[key] = &trackCopy
+}
+
+// remove removes all cached tracks matching the given filter.
+func (c *trackCache) remove(filter func(trackKey) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key := range c.tracks {
+		if filter(key) {
+			delete(c.tracks, key)
+		}
+	}
+}
+
+// setMounted records whether media is mounted in the slot, reporting true if
+// the media was mounted and has now been unmounted.
+func (c *trackCache) setMounted(key mediaKey, mounted bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	wasMounted := c.mounted[key]
+	c.mounted[key] = mounted
+
+	return wasMounted && !mounted
+}
+
+func newTrackCache() *trackCache {
+	return &trackCache{
+		tracks:  map[trackKey]*Track{},
+		mounted: map[mediaKey]bool{},
+	}
+}
@@ -0,0 +1,97 @@
+package prolink
+
+import (
+	"fmt"
+)
+
+// Protocol warning kinds
+const (
+	WarningUnknownPacketType WarningKind = "unknown_packet_type"
+	WarningUnexpectedLength  WarningKind = "unexpected_length"
+	WarningUnknownMenuItem   WarningKind = "unknown_menu_item"
+	WarningListenerStopped   WarningKind = "listener_stopped"
+	WarningNetworkSilent     WarningKind = "network_silent"
+	WarningStaleConnection   WarningKind = "stale_connection"
+	WarningTransactionID     WarningKind = "transaction_id"
+	WarningIDCollision       WarningKind = "id_collision"
+	WarningResumed           WarningKind = "resumed"
+	WarningNetworkChanged    WarningKind = "network_changed"
+)
+
+// WarningKind identifies the type of protocol anomaly a ProtocolWarning
+// describes.
+type WarningKind string
+
+// ProtocolWarning describes unexpected data that was received from a device on
+// the PRO DJ LINK network. Warnings are not fatal, the unexpected data is
+// ignored. They are reported so that the exact data sent by unfamiliar
+// hardware can be inspected.
+//
+// Warnings are also reported when the network supervisor restarts a failed
+// component, in which case Data is empty.
+type ProtocolWarning struct {
+	Kind    WarningKind
+	Message string
+	Data    []byte
+}
+
+func (w *ProtocolWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Kind, w.Message)
+}
+
+// packetError is returned when a packet does not contain the data that was
+// expected. The kind specifies what type of warning it should be reported as.
+type packetError struct {
+	kind    WarningKind
+	message string
+}
+
+func (e *packetError) Error() string {
+	return e.message
+}
+
+func newPacketError(kind WarningKind, format string, args ...interface{}) error {
+	return &packetError{kind: kind, message: fmt.Sprintf(format, args...)}
+}
+
+// warningBufferSize is the number of warnings that will be buffered before
+// further warnings are dropped.
+const warningBufferSize = 32
+
+// protocolWarner emits ProtocolWarnings onto a channel without blocking.
+type protocolWarner struct {
+	warnings chan *ProtocolWarning
+}
+
+// warn emits a ProtocolWarning. If the warnings channel is full the warning is
+// dropped, so that a consumer not reading warnings never blocks the network.
+func (w *protocolWarner) warn(kind WarningKind, data []byte, format string, args ...interface{}) {
+	warning := &ProtocolWarning{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Data:    append([]byte{}, data...),
+	}
+
+	select {
+	case w.warnings <- warning:
+	default:
+	}
+}
+
+// warnPacket emits a ProtocolWarning for an error returned while parsing a
+// packet.
+func (w *protocolWarner) warnPacket(err error, packet []byte) {
+	kind := WarningUnknownPacketType
+
+	if perr, ok := err.(*packetError); ok {
+		kind = perr.kind
+	}
+
+	w.warn(kind, packet, "%s", err)
+}
+
+func newProtocolWarner() *protocolWarner {
+	return &protocolWarner{
+		warnings: make(chan *ProtocolWarning, warningBufferSize),
+	}
+}
@@ -0,0 +1,280 @@
+package prolink
+
+import (
+	"image/color"
+)
+
+// WaveformColumn is a single column of a monochrome waveform.
+type WaveformColumn struct {
+	// Height is the height of the column, from 0 to 31.
+	Height uint8
+
+	// Whiteness is the intensity of the column color, from 0 (blue) to 7
+	// (white).
+	Whiteness uint8
+}
+
+// WaveformPreview is the overview waveform of an entire track, as displayed
+// along the bottom of the player's screen.
+type WaveformPreview struct {
+	Columns []WaveformColumn
+}
+
+// The waveform preview is made up of 400 two byte columns. The remaining data
+// following the columns is a smaller preview which is not used.
+const (
+	waveformPreviewColumns   = 400
+	waveformPreviewColumnLen = 2
+)
+
+// GetWaveformPreview queries the remote db for the waveform preview of a
+// track.
+func (rd *RemoteDB) GetWaveformPreview(q *TrackQuery) (*WaveformPreview, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if !rd.supports(q.DeviceID, featureWaveform) {
+		return nil, ErrRequestUnsupported
+	}
+
+	waveformRequest := &waveformPreviewRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+	}
+
+	resp, err := rd.executeRequest(q.DeviceID, waveformRequest, msgTypeWavePrev)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.arguments) < 4 {
+		return &WaveformPreview{}, nil
+	}
+
+	data, ok := resp.arguments[3].(fieldBinary)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+
+	return parseWaveformPreview([]byte(data)), nil
+}
+
+// parseWaveformPreview parses the columns of a waveform preview.
+func parseWaveformPreview(data []byte) *WaveformPreview {
+	count := len(data) / waveformPreviewColumnLen
+	if count > waveformPreviewColumns {
+		count = waveformPreviewColumns
+	}
+
+	preview := &WaveformPreview{
+		Columns: make([]WaveformColumn, count),
+	}
+
+	for i := range preview.Columns {
+		column := data[i*waveformPreviewColumnLen:]
+
+		preview.Columns[i] = WaveformColumn{
+			Height:    column[0] & 0x1f,
+			Whiteness: column[1] & 0x07,
+		}
+	}
+
+	return preview
+}
+
+// WaveformDetailColumnsPerSecond is the number of columns of a WaveformDetail
+// for each second of the track. Each column represents one half-frame.
+const WaveformDetailColumnsPerSecond = 150
+
+// WaveformDetail is the detailed waveform of a track, as displayed scrolling
+// across the player's screen during playback.
+type WaveformDetail struct {
+	Columns []WaveformColumn
+}
+
+// The detailed waveform data begins with a header that is not used. Each
+// following byte is a single column.
+const waveformDetailHeaderLen = 0x13
+
+// GetWaveformDetail queries the remote db for the detailed waveform of a
+// track. The entire waveform is returned in a single response, even for long
+// tracks.
+func (rd *RemoteDB) GetWaveformDetail(q *TrackQuery) (*WaveformDetail, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if !rd.supports(q.DeviceID, featureWaveform) {
+		return nil, ErrRequestUnsupported
+	}
+
+	waveformRequest := &waveformDetailRequestPacket{
+		deviceID: rd.requestingDeviceID(),
+		slot:     q.Slot,
+		trackID:  q.TrackID,
+	}
+
+	resp, err := rd.executeRequest(q.DeviceID, waveformRequest, msgTypeWaveDetail)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.arguments) < 4 {
+		return &WaveformDetail{}, nil
+	}
+
+	data, ok := resp.arguments[3].(fieldBinary)
+	if !ok {
+		return nil, ErrInvalidRequest
+	}
+
+	return parseWaveformDetail([]byte(data)), nil
+}
+
+// parseWaveformDetail parses the columns of a detailed waveform. Each column
+// is a single byte, the low 5 bits being the height and the high 3 bits the
+// whiteness.
+func parseWaveformDetail(data []byte) *WaveformDetail {
+	if len(data) < waveformDetailHeaderLen {
+		return &WaveformDetail{}
+	}
+
+	data = data[waveformDetailHeaderLen:]
+
+	detail := &WaveformDetail{
+		Columns: make([]WaveformColumn, len(data)),
+	}
+
+	for i, column := range data {
+		detail.Columns[i] = WaveformColumn{
+			Height:    column & 0x1f,
+			Whiteness: column >> 5,
+		}
+	}
+
+	return detail
+}
+
+// ColorWaveformColumn is a single column of a color waveform.
+type ColorWaveformColumn struct {
+	// Height is the height of the column, from 0 to 31.
+	Height uint8
+	Color  color.RGBA
+}
+
+// ColorWaveformDetail is the detailed color waveform of a track, as displayed
+// by nexus 2 and newer players. It has the same number of columns per second
+// as the WaveformDetail.
+type ColorWaveformDetail struct {
+	Columns []ColorWaveformColumn
+
+	// IsMonochrome is true when the player or track did not have color
+	// waveform data and the columns were instead derived from the monochrome
+	// WaveformDetail.
+	IsMonochrome bool
+}
+
+// The color waveform detail is retrieved from the PWV5 tag of the extended
+// analysis file. The tag header is followed by two byte columns.
+const colorWaveformColumnLen = 2
+
+// GetColorWaveformDetail queries the remote db for the detailed color waveform
+// of a track. Should the player not support color waveforms, or the track not
+// have been analyzed with color waveforms, the monochrome detailed waveform
+// is returned in its place with IsMonochrome set.
+func (rd *RemoteDB) GetColorWaveformDetail(q *TrackQuery) (*ColorWaveformDetail, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	if !rd.supports(q.DeviceID, featureAnlzTag) {
+		return rd.getMonochromeWaveformDetail(q)
+	}
+
+	data, err := rd.getAnlzTag(q, anlzTagColorWaveformDetail, anlzFileExtended)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return rd.getMonochromeWaveformDetail(q)
+	}
+
+	return parseColorWaveformDetail(data), nil
+}
+
+// getMonochromeWaveformDetail queries for the monochrome detailed waveform,
+// converting it into a ColorWaveformDetail.
+func (rd *RemoteDB) getMonochromeWaveformDetail(q *TrackQuery) (*ColorWaveformDetail, error) {
+	mono, err := rd.GetWaveformDetail(q)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &ColorWaveformDetail{
+		Columns:      make([]ColorWaveformColumn, len(mono.Columns)),
+		IsMonochrome: true,
+	}
+
+	for i, column := range mono.Columns {
+		detail.Columns[i] = ColorWaveformColumn{
+			Height: column.Height,
+			Color:  whitenessColor(column.Whiteness),
+		}
+	}
+
+	return detail, nil
+}
+
+// parseColorWaveformDetail parses the PWV5 analysis tag. Each column is a big
+// endian uint16, the top 9 bits being 3 bits each of red, green, and blue,
+// followed by 5 bits of height.
+func parseColorWaveformDetail(data []byte) *ColorWaveformDetail {
+	data = data[be.Uint32(data[anlzTagHeaderLenOffset:]):]
+
+	detail := &ColorWaveformDetail{
+		Columns: make([]ColorWaveformColumn, len(data)/colorWaveformColumnLen),
+	}
+
+	for i := range detail.Columns {
+		column := be.Uint16(data[i*colorWaveformColumnLen:])
+
+		detail.Columns[i] = ColorWaveformColumn{
+			Height: uint8(column>>2) & 0x1f,
+			Color: color.RGBA{
+				R: colorChannel(column >> 13),
+				G: colorChannel(column >> 10),
+				B: colorChannel(column >> 7),
+				A: 0xff,
+			},
+		}
+	}
+
+	return detail
+}
+
+// colorChannel scales the low 3 bits of a color waveform column into a full
+// 8 bit color channel.
+func colorChannel(v uint16) uint8 {
+	return uint8((v & 0x07) * 0xff / 0x07)
+}
+
+// waveformBlue is the color of a monochrome waveform column with no whiteness.
+var waveformBlue = color.RGBA{R: 0x00, G: 0x68, B: 0x90, A: 0xff}
+
+// whitenessColor blends the monochrome waveform blue towards white by the
+// whiteness of the column.
+func whitenessColor(whiteness uint8) color.RGBA {
+	blend := func(c uint8) uint8 {
+		return c + uint8(int(0xff-c)*int(whiteness)/0x07)
+	}
+
+	return color.RGBA{
+		R: blend(waveformBlue.R),
+		G: blend(waveformBlue.G),
+		B: blend(waveformBlue.B),
+		A: 0xff,
+	}
+}